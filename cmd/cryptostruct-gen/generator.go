@@ -0,0 +1,443 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	cryptostructImportPath = "github.com/corelayer/go-cryptostruct/pkg/cryptostruct"
+	generatedHeader        = "// Code generated by cryptostruct-gen. DO NOT EDIT."
+)
+
+// Tag keys which are copied to the generated CryptoParams field when they are used on the plain type
+var serializationTagKeys = []string{"json", "yaml", "mapstructure"}
+
+var basicTypes = map[string]bool{
+	"bool": true, "string": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true, "rune": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "byte": true,
+	"float32": true, "float64": true, "complex64": true, "complex128": true,
+}
+
+// Types from other packages which are encrypted as a single value, either because they are a basic type or
+// because they implement encoding.BinaryMarshaler or encoding.TextMarshaler
+var externalScalarTypes = map[string]bool{
+	"time.Time": true, "time.Duration": true, "time.Month": true, "time.Weekday": true,
+	"net.IP": true, "net/netip.Addr": true, "net/netip.Prefix": true, "net/netip.AddrPort": true,
+	"net/url.URL": true, "math/big.Int": true, "math/big.Float": true, "math/big.Rat": true,
+}
+
+type generator struct {
+	fset    *token.FileSet
+	prefix  string
+	pkgName string
+	// All struct types declared in the package, indexed by name
+	structs map[string]*ast.StructType
+	// Named types in the package with a basic or byte slice underlying type
+	scalars map[string]bool
+	// Named interface types in the package
+	interfaces map[string]bool
+	// Import paths by package name, collected from the parsed files
+	imports map[string]string
+	// Package names which are imported from different paths by different files
+	conflicts map[string][]string
+	// Types for which a secure twin is generated
+	selected map[string]bool
+	// Import paths referenced by the generated code
+	used map[string]string
+}
+
+func newGenerator(fset *token.FileSet, files []*ast.File, prefix string) (*generator, error) {
+	g := &generator{
+		fset:       fset,
+		prefix:     prefix,
+		structs:    make(map[string]*ast.StructType),
+		scalars:    make(map[string]bool),
+		interfaces: make(map[string]bool),
+		imports:    make(map[string]string),
+		conflicts:  make(map[string][]string),
+		selected:   make(map[string]bool),
+		used:       make(map[string]string),
+	}
+
+	for _, file := range files {
+		if g.pkgName == "" {
+			g.pkgName = file.Name.Name
+		} else if g.pkgName != file.Name.Name {
+			return nil, fmt.Errorf("found multiple packages: %s and %s", g.pkgName, file.Name.Name)
+		}
+
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return nil, err
+			}
+			name := path[strings.LastIndex(path, "/")+1:]
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			// A conflicting name is only an error when the generated code refers to it
+			if existing, ok := g.imports[name]; ok && existing != path {
+				g.conflicts[name] = append(g.conflicts[name], path)
+				continue
+			}
+			g.imports[name] = path
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			switch t := spec.Type.(type) {
+			case *ast.StructType:
+				g.structs[spec.Name.Name] = t
+			case *ast.InterfaceType:
+				g.interfaces[spec.Name.Name] = true
+			case *ast.Ident:
+				if basicTypes[t.Name] {
+					g.scalars[spec.Name.Name] = true
+				}
+			case *ast.ArrayType:
+				// Byte slices are encrypted as a single value
+				if isByteSlice(t) {
+					g.scalars[spec.Name.Name] = true
+				}
+			}
+			return false
+		})
+	}
+	return g, nil
+}
+
+// selectTypes marks the types for which a secure twin must be generated.
+// If no names are provided, all structs with at least one secure tag are selected.
+func (g *generator) selectTypes(names []string) error {
+	if len(names) == 0 {
+		for name, s := range g.structs {
+			if hasSecureTag(s) {
+				g.selected[name] = true
+			}
+		}
+		if len(g.selected) == 0 {
+			return fmt.Errorf("no structs with secure tags found in package %s", g.pkgName)
+		}
+		return nil
+	}
+
+	for _, name := range names {
+		if _, ok := g.structs[name]; !ok {
+			return fmt.Errorf("struct type %s not found in package %s", name, g.pkgName)
+		}
+		g.selected[name] = true
+	}
+	return nil
+}
+
+func (g *generator) generate() ([]byte, error) {
+	var (
+		err  error
+		body bytes.Buffer
+	)
+
+	names := make([]string, 0, len(g.selected))
+	for name := range g.selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	g.used["cryptostruct"] = cryptostructImportPath
+	for _, name := range names {
+		if err = g.generateType(&body, name); err != nil {
+			return nil, err
+		}
+	}
+
+	var output bytes.Buffer
+	fmt.Fprintf(&output, "%s\n\npackage %s\n\n", generatedHeader, g.pkgName)
+	output.WriteString("import (\n")
+	for _, name := range sortedKeys(g.used) {
+		path := g.used[name]
+		if path[strings.LastIndex(path, "/")+1:] == name {
+			fmt.Fprintf(&output, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(&output, "\t%s %q\n", name, path)
+		}
+	}
+	output.WriteString(")\n")
+	output.Write(body.Bytes())
+
+	return format.Source(output.Bytes())
+}
+
+func (g *generator) generateType(w *bytes.Buffer, name string) error {
+	var (
+		secureName = g.prefix + name
+		tagKeys    = make(map[string]bool)
+	)
+
+	fmt.Fprintf(w, "\ntype %s struct {\n", secureName)
+	if err := g.generateFields(w, name, g.structs[name], tagKeys); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\tCryptoParams cryptostruct.CryptoParams %s\n", cryptoParamsTag(tagKeys))
+	w.WriteString("}\n")
+
+	fmt.Fprintf(w, "\nfunc (d %s) GetTransformConfig() cryptostruct.TransformConfig {\n", name)
+	fmt.Fprintf(w, "\treturn cryptostruct.TransformConfig{\n\t\tDecrypted: %s{},\n\t\tEncrypted: %s{},\n\t}\n}\n", name, secureName)
+	fmt.Fprintf(w, "\nfunc (d %s) GetTransformConfig() cryptostruct.TransformConfig {\n", secureName)
+	fmt.Fprintf(w, "\treturn cryptostruct.TransformConfig{\n\t\tDecrypted: %s{},\n\t\tEncrypted: %s{},\n\t}\n}\n", name, secureName)
+	fmt.Fprintf(w, "\nfunc (d %s) GetCryptoParams() cryptostruct.CryptoParams {\n\treturn d.CryptoParams\n}\n", secureName)
+	return nil
+}
+
+// generateFields writes the fields of the secure twin for the fields of struct s, and collects the serialization
+// tag keys used by the fields in tagKeys
+func (g *generator) generateFields(w *bytes.Buffer, name string, s *ast.StructType, tagKeys map[string]bool) error {
+	var (
+		err       error
+		fieldType string
+	)
+
+	for _, field := range s.Fields.List {
+		tag := ""
+		if field.Tag != nil {
+			tag = field.Tag.Value
+			structTag := reflect.StructTag(strings.Trim(tag, "`"))
+			for _, key := range serializationTagKeys {
+				if _, ok := structTag.Lookup(key); ok {
+					tagKeys[key] = true
+				}
+			}
+		}
+
+		if len(field.Names) == 0 {
+			if err = g.generateEmbeddedField(w, name, field, tag, tagKeys); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isSecure(field) {
+			if fieldType, err = g.secureType(field.Type); err != nil {
+				return fmt.Errorf("%s.%s: %w", name, field.Names[0].Name, err)
+			}
+		} else if fieldType, err = g.plainType(field.Type); err != nil {
+			return fmt.Errorf("%s.%s: %w", name, field.Names[0].Name, err)
+		}
+
+		for _, fieldName := range field.Names {
+			fmt.Fprintf(w, "\t%s %s %s\n", fieldName.Name, fieldType, tag)
+		}
+	}
+	return nil
+}
+
+// generateEmbeddedField writes an embedded field the same way it is handled by the Encrypter:
+// embedded structs without secure tag are flattened, embedded structs with secure tag "true" are replaced by their
+// secure twin and all other embedded fields are copied as-is.
+func (g *generator) generateEmbeddedField(w *bytes.Buffer, name string, field *ast.Field, tag string, tagKeys map[string]bool) error {
+	embedded := g.render(field.Type)
+
+	if !hasTag(field, "secure") {
+		ident, ok := field.Type.(*ast.Ident)
+		if !ok || g.structs[ident.Name] == nil {
+			return fmt.Errorf("%s: embedded field %s without secure tag must be a struct declared in package %s", name, embedded, g.pkgName)
+		}
+		return g.generateFields(w, name+"."+ident.Name, g.structs[ident.Name], tagKeys)
+	}
+
+	if !isSecure(field) {
+		embeddedType, err := g.plainType(field.Type)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Fprintf(w, "\t%s %s\n", embeddedType, tag)
+		return nil
+	}
+
+	ident, ok := field.Type.(*ast.Ident)
+	if !ok || !g.selected[ident.Name] {
+		return fmt.Errorf("%s: embedded field %s with secure tag must be a struct selected for generation", name, embedded)
+	}
+	fmt.Fprintf(w, "\t%s %s\n", g.prefix+ident.Name, tag)
+	return nil
+}
+
+// secureType returns the type of an encrypted field on the secure twin for the plain type expr.
+func (g *generator) secureType(expr ast.Expr) (string, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if g.selected[t.Name] {
+			return g.prefix + t.Name, nil
+		}
+		// Interfaces are encrypted together with the name of the concrete type registered using cryptostruct.RegisterType
+		if basicTypes[t.Name] || g.scalars[t.Name] || g.interfaces[t.Name] || t.Name == "any" {
+			return "string", nil
+		}
+		if _, ok := g.structs[t.Name]; ok {
+			return "", fmt.Errorf("struct type %s is not selected for generation", t.Name)
+		}
+		return "", fmt.Errorf("unsupported type %s", t.Name)
+	case *ast.ArrayType:
+		if isByteSlice(t) {
+			return "string", nil
+		}
+		elem, err := g.secureType(t.Elt)
+		if err != nil {
+			return "", err
+		}
+		if t.Len != nil {
+			// Arrays keep their length on the secure type
+			return "[" + g.render(t.Len) + "]" + elem, nil
+		}
+		return "[]" + elem, nil
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok {
+			path, err := g.importPath(pkg.Name)
+			if err != nil {
+				return "", err
+			}
+			if externalScalarTypes[path+"."+t.Sel.Name] {
+				return "string", nil
+			}
+		}
+		return "", fmt.Errorf("unsupported type %s", g.render(t))
+	case *ast.InterfaceType:
+		return "string", nil
+	case *ast.StarExpr:
+		elem, err := g.secureType(t.X)
+		if err != nil {
+			return "", err
+		}
+		return "*" + elem, nil
+	case *ast.MapType:
+		// Map keys are copied as-is, only the values are encrypted
+		elem, err := g.secureType(t.Value)
+		if err != nil {
+			return "", err
+		}
+		key, err := g.plainType(t.Key)
+		if err != nil {
+			return "", err
+		}
+		return "map[" + key + "]" + elem, nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", g.render(t))
+	}
+}
+
+// plainType returns the type of a field which is copied as-is to the secure twin
+func (g *generator) plainType(expr ast.Expr) (string, error) {
+	var err error
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok && err == nil {
+				g.used[pkg.Name], err = g.importPath(pkg.Name)
+			}
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	return g.render(expr), nil
+}
+
+// importPath returns the import path of the package imported as name
+func (g *generator) importPath(name string) (string, error) {
+	if paths, ok := g.conflicts[name]; ok {
+		return "", fmt.Errorf("package name %s is imported from %s and %s, use an import alias", name, g.imports[name], strings.Join(paths, " and "))
+	}
+	path, ok := g.imports[name]
+	if !ok {
+		return "", fmt.Errorf("package %s is not imported", name)
+	}
+	return path, nil
+}
+
+func (g *generator) render(expr ast.Expr) string {
+	var b bytes.Buffer
+	_ = printer.Fprint(&b, g.fset, expr)
+	return b.String()
+}
+
+func isByteSlice(t *ast.ArrayType) bool {
+	elem, ok := t.Elt.(*ast.Ident)
+	return ok && t.Len == nil && (elem.Name == "byte" || elem.Name == "uint8")
+}
+
+func hasSecureTag(s *ast.StructType) bool {
+	for _, field := range s.Fields.List {
+		if hasTag(field, "secure") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(field *ast.Field, key string) bool {
+	if field.Tag == nil {
+		return false
+	}
+	_, ok := reflect.StructTag(strings.Trim(field.Tag.Value, "`")).Lookup(key)
+	return ok
+}
+
+func isSecure(field *ast.Field) bool {
+	if field.Tag == nil {
+		return false
+	}
+	// Options follow the first value, e.g. secure:"true,omitempty"
+	tag := reflect.StructTag(strings.Trim(field.Tag.Value, "`")).Get("secure")
+	enabled, _, _ := strings.Cut(tag, ",")
+	return enabled == "true"
+}
+
+func cryptoParamsTag(keys map[string]bool) string {
+	if len(keys) == 0 {
+		keys = map[string]bool{"json": true, "yaml": true, "mapstructure": true}
+	}
+	tags := make([]string, 0, len(keys))
+	for _, key := range serializationTagKeys {
+		if keys[key] {
+			tags = append(tags, fmt.Sprintf("%s:\"cryptoParams\"", key))
+		}
+	}
+	return "`" + strings.Join(tags, " ") + "`"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const testSource = `package data
+
+import "time"
+
+type Level int
+
+type PEM []byte
+
+type Shape interface {
+	Area() float64
+}
+
+type Details struct {
+	Age int ` + "`json:\"age\" secure:\"false\"`" + `
+}
+
+type Data struct {
+	Name    string        ` + "`json:\"name\" yaml:\"name\" secure:\"true\"`" + `
+	Level   Level         ` + "`json:\"level\" yaml:\"level\" secure:\"true\"`" + `
+	Numbers []int         ` + "`json:\"numbers\" yaml:\"numbers\" secure:\"true\"`" + `
+	Details []Details     ` + "`json:\"details\" yaml:\"details\" secure:\"true\"`" + `
+	Owners  map[string]Details ` + "`json:\"owners\" yaml:\"owners\" secure:\"true\"`" + `
+	Manager *Details ` + "`json:\"manager\" yaml:\"manager\" secure:\"true\"`" + `
+	Backup  [2]Details ` + "`json:\"backup\" yaml:\"backup\" secure:\"true\"`" + `
+	Key     []byte ` + "`json:\"key\" yaml:\"key\" secure:\"true\"`" + `
+	Cert    PEM ` + "`json:\"cert\" yaml:\"cert\" secure:\"true\"`" + `
+	Expiry  time.Time ` + "`json:\"expiry\" yaml:\"expiry\" secure:\"true\"`" + `
+	Extra   any ` + "`json:\"extra\" yaml:\"extra\" secure:\"true\"`" + `
+	Shape   Shape ` + "`json:\"shape\" yaml:\"shape\" secure:\"true\"`" + `
+	Timeout time.Duration ` + "`json:\"timeout\" yaml:\"timeout\" secure:\"false\"`" + `
+	Pin     int ` + "`json:\"pin\" yaml:\"pin\" secure:\"true,omitempty\"`" + `
+}
+`
+
+func generateFromSource(t *testing.T, src string, names []string) (string, error) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "data.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	g, err := newGenerator(fset, []*ast.File{file}, "Secure")
+	if err != nil {
+		t.Fatalf("newGenerator() error = %v", err)
+	}
+	if err = g.selectTypes(names); err != nil {
+		return "", err
+	}
+	output, err := g.generate()
+	return string(output), err
+}
+
+func TestGenerator_Generate(t *testing.T) {
+	output, err := generateFromSource(t, testSource, nil)
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+
+	if _, err = parser.ParseFile(token.NewFileSet(), "output.go", output, 0); err != nil {
+		t.Fatalf("generated code does not parse: %v", err)
+	}
+
+	expected := []string{
+		generatedHeader,
+		`"time"`,
+		"type SecureData struct",
+		"Name         string                    `json:\"name\" yaml:\"name\" secure:\"true\"`",
+		"Level        string ",
+		"Numbers      []string ",
+		"Details      []SecureDetails ",
+		"Owners       map[string]SecureDetails ",
+		"Manager      *SecureDetails ",
+		"Backup       [2]SecureDetails ",
+		"Key          string ",
+		"Cert         string ",
+		"Expiry       string ",
+		"Extra        string ",
+		"Shape        string ",
+		"Timeout      time.Duration ",
+		"Pin          string ",
+		"CryptoParams cryptostruct.CryptoParams `json:\"cryptoParams\" yaml:\"cryptoParams\"`",
+		"type SecureDetails struct",
+		"CryptoParams cryptostruct.CryptoParams `json:\"cryptoParams\"`",
+		"func (d Data) GetTransformConfig() cryptostruct.TransformConfig",
+		"func (d SecureData) GetCryptoParams() cryptostruct.CryptoParams",
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("generated code does not contain %q:\n%s", e, output)
+		}
+	}
+}
+
+const testEmbeddedSource = `package data
+
+type Address struct {
+	City string ` + "`json:\"city\" secure:\"true\"`" + `
+}
+
+type Credentials struct {
+	Username string ` + "`json:\"username\" secure:\"true\"`" + `
+}
+
+type Service struct {
+	Address
+	Credentials ` + "`json:\"credentials\" secure:\"true\"`" + `
+	Host        string ` + "`json:\"host\" secure:\"true\"`" + `
+}
+`
+
+func TestGenerator_EmbeddedFields(t *testing.T) {
+	output, err := generateFromSource(t, testEmbeddedSource, []string{"Service", "Credentials"})
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+
+	expected := []string{
+		// Embedded structs without secure tag are flattened
+		"City              string `json:\"city\" secure:\"true\"`",
+		// Embedded structs with secure tag are replaced by their secure twin
+		"SecureCredentials `json:\"credentials\" secure:\"true\"`",
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("generated code does not contain %q:\n%s", e, output)
+		}
+	}
+}
+
+func TestGenerator_UnselectedStruct(t *testing.T) {
+	if _, err := generateFromSource(t, testSource, []string{"Data"}); err == nil {
+		t.Error("generate() expected error for secure field of unselected struct type")
+	}
+}
+
+func TestGenerator_UnknownType(t *testing.T) {
+	if _, err := generateFromSource(t, testSource, []string{"Unknown"}); err == nil {
+		t.Error("selectTypes() expected error for unknown type")
+	}
+}
+
+func TestGenerator_ConflictingImports(t *testing.T) {
+	sources := []string{
+		"package data\n\nimport \"crypto/rand\"\n\nvar _ = rand.Reader\n",
+		"package data\n\nimport \"math/rand\"\n\ntype Data struct {\n\tSource rand.Source `secure:\"false\"`\n\tName string `secure:\"true\"`\n}\n",
+	}
+
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, len(sources))
+	for i, src := range sources {
+		file, err := parser.ParseFile(fset, fmt.Sprintf("data%d.go", i), src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("ParseFile() error = %v", err)
+		}
+		files = append(files, file)
+	}
+
+	g, err := newGenerator(fset, files, "Secure")
+	if err != nil {
+		t.Fatalf("newGenerator() error = %v", err)
+	}
+	if err = g.selectTypes(nil); err != nil {
+		t.Fatalf("selectTypes() error = %v", err)
+	}
+	if _, err = g.generate(); err == nil {
+		t.Error("generate() expected error for package name imported from different paths")
+	}
+}
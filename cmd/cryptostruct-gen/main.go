@@ -0,0 +1,144 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Command cryptostruct-gen generates the secure twin of structs using secure tags, together with the
+// GetTransformConfig and GetCryptoParams methods required by package cryptostruct.
+//
+// It is meant to be used with go generate:
+//
+//	//go:generate go run github.com/corelayer/go-cryptostruct/cmd/cryptostruct-gen -type Credentials,Service
+//
+// Fields tagged with secure:"true" are converted to their encrypted representation, all other fields are copied
+// with their original type. Struct tags are copied verbatim, and a CryptoParams field is added to every secure type.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	var (
+		typeNames = flag.String("type", "", "comma-separated list of type names; defaults to all structs with secure tags")
+		prefix    = flag.String("prefix", "Secure", "prefix for the name of the generated secure types")
+		output    = flag.String("output", "", "output file name; defaults to <file>_cryptostruct.go")
+		dir       = flag.String("dir", ".", "directory of the package to process")
+	)
+	log.SetFlags(0)
+	log.SetPrefix("cryptostruct-gen: ")
+	flag.Parse()
+
+	if err := run(*dir, *typeNames, *prefix, *output); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(dir string, typeNames string, prefix string, output string) error {
+	var (
+		err   error
+		files []*ast.File
+		names []string
+		src   []byte
+		g     *generator
+	)
+
+	if output == "" {
+		output = defaultOutputName()
+	}
+	output = filepath.Join(dir, output)
+
+	fset := token.NewFileSet()
+	if files, err = parsePackage(fset, dir, output); err != nil {
+		return err
+	}
+
+	if g, err = newGenerator(fset, files, prefix); err != nil {
+		return err
+	}
+
+	if typeNames != "" {
+		names = strings.Split(typeNames, ",")
+	}
+	if err = g.selectTypes(names); err != nil {
+		return err
+	}
+
+	if src, err = g.generate(); err != nil {
+		return err
+	}
+	return os.WriteFile(output, src, 0o644)
+}
+
+// parsePackage parses all non-test Go files in dir, skipping the output file and previously generated files.
+func parsePackage(fset *token.FileSet, dir string, output string) ([]*ast.File, error) {
+	var (
+		err   error
+		paths []string
+		files []*ast.File
+	)
+
+	if paths, err = filepath.Glob(filepath.Join(dir, "*.go")); err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || filepath.Clean(path) == filepath.Clean(output) {
+			continue
+		}
+
+		var file *ast.File
+		if file, err = parser.ParseFile(fset, path, nil, parser.ParseComments); err != nil {
+			return nil, err
+		}
+		if isGenerated(file) {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files found in %s", dir)
+	}
+	return files, nil
+}
+
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if comment.Text == generatedHeader {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func defaultOutputName() string {
+	if file := os.Getenv("GOFILE"); file != "" {
+		return strings.TrimSuffix(file, ".go") + "_cryptostruct.go"
+	}
+	return "cryptostruct_gen.go"
+}
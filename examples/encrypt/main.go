@@ -28,8 +28,7 @@ func main() {
 	var (
 		err error
 		p   cryptostruct.CryptoParams
-		tc  cryptostruct.TransformConfig
-		sd  any
+		sd  data.SecureData
 	)
 	fmt.Println("ENCRYPTING DATA")
 	fmt.Println("_______________")
@@ -62,14 +61,15 @@ func main() {
 	}
 	fmt.Println(id)
 
-	tc = id.GetTransformConfig()
-
 	p, err = cryptostruct.NewCryptoParams("AES_256_GCM")
 	if err != nil {
 		panic(err)
 	}
 	masterKey := hex.EncodeToString([]byte("masterKey"))
-	encrypter := cryptostruct.NewEncrypter(masterKey, p, tc)
+	encrypter, err := cryptostruct.NewTypedEncrypter[data.InsecureData, data.SecureData](masterKey, p)
+	if err != nil {
+		panic(err)
+	}
 
 	sd, err = encrypter.Transform(id)
 	if err != nil {
@@ -90,12 +90,13 @@ func main() {
 	fmt.Println("DECRYPTING CONFIG")
 	fmt.Println("_________________")
 
-	decryptdata := sd.(data.SecureData)
-	// fmt.Println(decryptdata, reflect.TypeOf(decryptdata))
-	decrypter := cryptostruct.NewDecrypter(masterKey, decryptdata.GetTransformConfig())
-	newid, err2 := decrypter.Transform(decryptdata)
-	if err2 != nil {
-		panic(err2)
+	decrypter, err := cryptostruct.NewTypedDecrypter[data.SecureData, data.InsecureData](masterKey)
+	if err != nil {
+		panic(err)
+	}
+	newid, err := decrypter.Transform(sd)
+	if err != nil {
+		panic(err)
 	}
 	fmt.Println(newid, reflect.TypeOf(newid))
 	// fmt.Println("")
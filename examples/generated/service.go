@@ -0,0 +1,32 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package generated
+
+//go:generate go run github.com/corelayer/go-cryptostruct/cmd/cryptostruct-gen -type Credentials,Service
+
+type Credentials struct {
+	Username string `json:"username" yaml:"username" mapstructure:"username" secure:"true"`
+	Password string `json:"password" yaml:"password" mapstructure:"password" secure:"true"`
+}
+
+type Service struct {
+	Name        string        `json:"name" yaml:"name" mapstructure:"name" secure:"false"`
+	Port        int           `json:"port" yaml:"port" mapstructure:"port" secure:"true"`
+	Credentials Credentials   `json:"credentials" yaml:"credentials" mapstructure:"credentials" secure:"true"`
+	Fallback    []Credentials `json:"fallback" yaml:"fallback" mapstructure:"fallback" secure:"true"`
+	Tags        []string      `json:"tags" yaml:"tags" mapstructure:"tags" secure:"false"`
+}
@@ -18,52 +18,173 @@ package cryptostruct
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"math"
 	"reflect"
 )
 
 func getEmbeddedTransformConfig(field reflect.Value) TransformConfig {
+	// GetTransformConfig can be declared on a pointer receiver
+	if !field.Type().Implements(encryptTransformerType) {
+		pointer := reflect.New(field.Type())
+		pointer.Elem().Set(field)
+		field = pointer
+	}
 	fnConfig := field.MethodByName("GetTransformConfig")
 	fnOutput := fnConfig.Call([]reflect.Value{})
 	return fnOutput[0].Interface().(TransformConfig)
 }
 
+// convertValueToHexString serializes a scalar value using convertValueToBytes and returns it as a hex encoded string.
+// Before version 2 of CryptoParams, scalar values, including the scalar values of interface fields, were hex encoded
+// before they were encrypted, since version 2 they are encrypted as raw bytes.
 func convertValueToHexString(v reflect.Value) (string, error) {
+	data, err := convertValueToBytes(v)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// convertValueToBytes serializes a scalar value.
+// Signed integers are stored as 8 bytes int64, unsigned integers as 8 bytes uint64, floats as 8 bytes float64
+// and complex numbers as 16 bytes complex128, all in big endian byte order, so the width of the Go type does not
+// influence the encrypted output. Named types are serialized according to their underlying kind.
+func convertValueToBytes(v reflect.Value) ([]byte, error) {
 	var (
 		err error
 	)
+	buf := make([]byte, 0)
+	bufWriter := bytes.NewBuffer(buf)
+
 	switch v.Kind() {
-	case reflect.Int:
-		buf := make([]byte, 0)
-		bufWriter := bytes.NewBuffer(buf)
+	case reflect.Bool:
+		err = binary.Write(bufWriter, binary.BigEndian, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		err = binary.Write(bufWriter, binary.BigEndian, v.Int())
-		if err != nil {
-			return "", err
-		}
-		return hex.EncodeToString(bufWriter.Bytes()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		err = binary.Write(bufWriter, binary.BigEndian, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		err = binary.Write(bufWriter, binary.BigEndian, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		err = binary.Write(bufWriter, binary.BigEndian, v.Complex())
+	case reflect.String:
+		_, err = bufWriter.WriteString(v.String())
 	default:
-		return hex.EncodeToString([]byte(v.String())), nil
+		return nil, fmt.Errorf("%w %s for type %s", ErrUnsupportedKind, v.Kind(), v.Type())
+	}
+	if err != nil {
+		return nil, err
 	}
+	return bufWriter.Bytes(), nil
 }
 
-func convertHexStringToValue(input string, outputKind reflect.Kind) (reflect.Value, error) {
-	var (
-		err     error
-		decoded []byte
-	)
+// convertHexStringToValue restores a value of outputType from a hex encoded string created by convertValueToHexString.
+func convertHexStringToValue(input string, outputType reflect.Type) (reflect.Value, error) {
 	// Decode hex encoded string to []byte
-	decoded, err = hex.DecodeString(input)
+	decoded, err := hex.DecodeString(input)
 	if err != nil {
 		return reflect.Value{}, err
 	}
+	return convertBytesToValue(decoded, outputType)
+}
 
-	// Create reflect.Value based on the output reflect.Kind
-	switch outputKind {
-	case reflect.Int:
-		decodedInt := binary.BigEndian.Uint64(decoded)
-		return reflect.ValueOf(int(decodedInt)), nil
+// convertBytesToValue restores a value of outputType from data created by convertValueToBytes.
+// An error is returned if the decoded data does not fit into outputType.
+func convertBytesToValue(decoded []byte, outputType reflect.Type) (reflect.Value, error) {
+	var err error
+
+	// Create reflect.Value based on the output reflect.Type
+	output := reflect.New(outputType).Elem()
+	switch outputType.Kind() {
+	case reflect.Bool:
+		if err = checkDecodedLength(decoded, 1, outputType); err != nil {
+			return reflect.Value{}, err
+		}
+		output.SetBool(decoded[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if err = checkDecodedLength(decoded, 8, outputType); err != nil {
+			return reflect.Value{}, err
+		}
+		decodedInt := int64(binary.BigEndian.Uint64(decoded))
+		if output.OverflowInt(decodedInt) {
+			return reflect.Value{}, fmt.Errorf("value %d overflows type %s", decodedInt, outputType)
+		}
+		output.SetInt(decodedInt)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if err = checkDecodedLength(decoded, 8, outputType); err != nil {
+			return reflect.Value{}, err
+		}
+		decodedUint := binary.BigEndian.Uint64(decoded)
+		if output.OverflowUint(decodedUint) {
+			return reflect.Value{}, fmt.Errorf("value %d overflows type %s", decodedUint, outputType)
+		}
+		output.SetUint(decodedUint)
+	case reflect.Float32, reflect.Float64:
+		if err = checkDecodedLength(decoded, 8, outputType); err != nil {
+			return reflect.Value{}, err
+		}
+		decodedFloat := math.Float64frombits(binary.BigEndian.Uint64(decoded))
+		if output.OverflowFloat(decodedFloat) {
+			return reflect.Value{}, fmt.Errorf("value %g overflows type %s", decodedFloat, outputType)
+		}
+		output.SetFloat(decodedFloat)
+	case reflect.Complex64, reflect.Complex128:
+		if err = checkDecodedLength(decoded, 16, outputType); err != nil {
+			return reflect.Value{}, err
+		}
+		decodedComplex := complex(
+			math.Float64frombits(binary.BigEndian.Uint64(decoded[:8])),
+			math.Float64frombits(binary.BigEndian.Uint64(decoded[8:])),
+		)
+		if output.OverflowComplex(decodedComplex) {
+			return reflect.Value{}, fmt.Errorf("value %g overflows type %s", decodedComplex, outputType)
+		}
+		output.SetComplex(decodedComplex)
+	case reflect.String:
+		output.SetString(string(decoded))
 	default:
-		return reflect.ValueOf(string(decoded)), nil
+		return reflect.Value{}, fmt.Errorf("%w %s for type %s", ErrUnsupportedKind, outputType.Kind(), outputType)
+	}
+	return output, nil
+}
+
+func checkDecodedLength(decoded []byte, length int, outputType reflect.Type) error {
+	if len(decoded) != length {
+		return fmt.Errorf("invalid data length %d for type %s, expected %d bytes", len(decoded), outputType, length)
+	}
+	return nil
+}
+
+// marshalValue serializes v using encoding.TextMarshaler if text is true, or encoding.BinaryMarshaler otherwise
+func marshalValue(v reflect.Value, text bool) ([]byte, error) {
+	// Use a pointer, so marshalers with a pointer receiver are found as well
+	value := reflect.New(v.Type())
+	value.Elem().Set(v)
+
+	if text {
+		return value.Interface().(encoding.TextMarshaler).MarshalText()
+	}
+	return value.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// unmarshalValue restores a value of outputType using encoding.TextUnmarshaler if text is true,
+// or encoding.BinaryUnmarshaler otherwise
+func unmarshalValue(data []byte, outputType reflect.Type, text bool) (reflect.Value, error) {
+	var err error
+
+	// Unmarshalers always have a pointer receiver
+	output := reflect.New(outputType)
+	if text {
+		err = output.Interface().(encoding.TextUnmarshaler).UnmarshalText(data)
+	} else {
+		err = output.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	}
+	if err != nil {
+		return reflect.Value{}, err
 	}
+	return output.Elem(), nil
 }
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+type namedString string
+
+type namedUint16 uint16
+
+type namedFloat float32
+
+func TestConvertValueToHexString_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input any
+	}{
+		{name: "bool true", input: true},
+		{name: "bool false", input: false},
+		{name: "int", input: int(-130586)},
+		{name: "int8", input: int8(math.MinInt8)},
+		{name: "int16", input: int16(math.MaxInt16)},
+		{name: "int32", input: int32(math.MinInt32)},
+		{name: "int64", input: int64(math.MaxInt64)},
+		{name: "uint", input: uint(130586)},
+		{name: "uint8", input: uint8(math.MaxUint8)},
+		{name: "uint16", input: uint16(math.MaxUint16)},
+		{name: "uint32", input: uint32(math.MaxUint32)},
+		{name: "uint64", input: uint64(math.MaxUint64)},
+		{name: "float32", input: float32(3.1415927)},
+		{name: "float64", input: math.SmallestNonzeroFloat64},
+		{name: "complex64", input: complex64(complex(1.5, -2.25))},
+		{name: "complex128", input: complex(math.MaxFloat64, math.Inf(-1))},
+		{name: "string", input: "insecuredata"},
+		{name: "empty string", input: ""},
+		{name: "named string", input: namedString("named")},
+		{name: "named uint16", input: namedUint16(65000)},
+		{name: "named float32", input: namedFloat(-0.5)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := convertValueToHexString(reflect.ValueOf(tt.input))
+			if err != nil {
+				t.Fatalf("convertValueToHexString() error = %v", err)
+			}
+			decoded, err := convertHexStringToValue(encoded, reflect.TypeOf(tt.input))
+			if err != nil {
+				t.Fatalf("convertHexStringToValue() error = %v", err)
+			}
+			if decoded.Interface() != tt.input {
+				t.Errorf("round trip = %#v, want %#v", decoded.Interface(), tt.input)
+			}
+		})
+	}
+}
+
+func TestConvertValueToHexString_UnsupportedKind(t *testing.T) {
+	inputs := []any{
+		make(chan int),
+		func() {},
+		struct{}{},
+		map[string]string{},
+	}
+
+	for _, input := range inputs {
+		if _, err := convertValueToHexString(reflect.ValueOf(input)); err == nil {
+			t.Errorf("convertValueToHexString(%T) expected error", input)
+		}
+		if _, err := convertHexStringToValue("", reflect.TypeOf(input)); err == nil {
+			t.Errorf("convertHexStringToValue(%T) expected error", input)
+		}
+	}
+}
+
+func TestConvertHexStringToValue_Overflow(t *testing.T) {
+	encoded, err := convertValueToHexString(reflect.ValueOf(int64(math.MaxInt16 + 1)))
+	if err != nil {
+		t.Fatalf("convertValueToHexString() error = %v", err)
+	}
+	if _, err = convertHexStringToValue(encoded, reflect.TypeOf(int16(0))); err == nil {
+		t.Error("convertHexStringToValue() expected overflow error")
+	}
+
+	encoded, err = convertValueToHexString(reflect.ValueOf(uint64(math.MaxUint32 + 1)))
+	if err != nil {
+		t.Fatalf("convertValueToHexString() error = %v", err)
+	}
+	if _, err = convertHexStringToValue(encoded, reflect.TypeOf(uint32(0))); err == nil {
+		t.Error("convertHexStringToValue() expected overflow error")
+	}
+}
+
+func TestConvertHexStringToValue_InvalidLength(t *testing.T) {
+	if _, err := convertHexStringToValue("0102", reflect.TypeOf(0)); err == nil {
+		t.Error("convertHexStringToValue() expected length error")
+	}
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/minio/sio"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// Version 0: ciphertexts are not bound to their location
+	// Version 1: ciphertexts are bound to the secure type, field path and CryptoParams
+	// Version 2: scalar values are encrypted as raw bytes instead of hex encoded strings
+	cryptoParamsVersion = 2
+
+	boundVersion        = 1
+	rawPlaintextVersion = 2
+
+	associatedDataSize = sha256.Size
+	keyCheckSize       = 16
+)
+
+// cryptoContext holds everything needed to encrypt and decrypt the fields of a single struct
+type cryptoContext struct {
+	config sio.Config
+	// Master key of the struct, deterministic fields derive their key from it
+	masterKey []byte
+	params    CryptoParams
+	// Type the ciphertexts are stored in
+	secureType reflect.Type
+	// Associated data shared by all fields of the struct
+	header []byte
+	// Ciphertexts are bound to their field, false for ciphertexts created before version 1
+	bound bool
+	// Scalar values are encrypted as raw bytes, false for ciphertexts created before version 2
+	rawPlaintext bool
+	// Encoding of ciphertexts stored in strings, hex if empty
+	encoding string
+	// Key to derive the random value of a ciphertext from its plaintext, only set for deterministic fields,
+	// which are bound to their field name in the header instead of to their path
+	siv []byte
+}
+
+func newCryptoContext(params CryptoParams, key []byte, secureType reflect.Type) (*cryptoContext, error) {
+	var (
+		err    error
+		config sio.Config
+	)
+
+	switch params.Encoding {
+	case "", EncodingHex, EncodingBase64, EncodingBase64URL:
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", params.Encoding)
+	}
+
+	// Generate sio.Config from CryptoParams once, it is shared by all fields and slice elements of the struct
+	if config, err = params.getCryptoConfig(key); err != nil {
+		return nil, fmt.Errorf("could not initialize crypto parameters: %w", err)
+	}
+
+	return &cryptoContext{
+		config:       config,
+		masterKey:    key,
+		params:       params,
+		secureType:   secureType,
+		header:       associatedDataHeader(params, secureType),
+		bound:        params.Version >= boundVersion,
+		rawPlaintext: params.Version >= rawPlaintextVersion,
+		encoding:     params.Encoding,
+	}, nil
+}
+
+// field returns the context for the field name using the options in tag, c itself is returned if tag has no options.
+func (c *cryptoContext) field(tag Tag, name string) (*cryptoContext, error) {
+	var err error
+
+	if !tag.hasCryptoOptions() {
+		return c, nil
+	}
+
+	f := *c
+	if tag.Encoding != "" {
+		f.encoding = tag.Encoding
+	}
+	if tag.Deterministic {
+		// The key of the struct depends on its nonce, so deterministic fields use a key derived from the master key
+		// and the secure type, and are bound to the field name, so equal values in a field are equal across structs.
+		// The fields share their key, so a ciphertext moved to another field is reported as tampered.
+		f.header = deterministicHeader(c.params, c.secureType, tag.Key, name)
+		if f.config.Key, err = deriveKey(c.masterKey, string(deterministicHeader(c.params, c.secureType, tag.Key, ""))); err != nil {
+			return nil, err
+		}
+		if f.siv, err = deriveKey(f.config.Key, "cryptostruct deterministic"); err != nil {
+			return nil, err
+		}
+		return &f, nil
+	}
+	if tag.Key != "" {
+		if f.config.Key, err = deriveKey(c.config.Key, "cryptostruct key "+tag.Key); err != nil {
+			return nil, err
+		}
+	}
+	return &f, nil
+}
+
+// keyCheck returns the value identifying the key of the struct, an HMAC of a fixed label under the key
+func (c *cryptoContext) keyCheck() string {
+	mac := hmac.New(sha256.New, c.config.Key)
+	mac.Write([]byte("cryptostruct key check"))
+	return hex.EncodeToString(mac.Sum(nil)[:keyCheckSize])
+}
+
+// verifyKeyCheck verifies that the key of the struct matches the keyCheck stored in its CryptoParams
+func (c *cryptoContext) verifyKeyCheck(keyCheck string) error {
+	if subtle.ConstantTimeCompare([]byte(c.keyCheck()), []byte(keyCheck)) != 1 {
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// deriveKey derives a 32 byte key for purpose from key
+func deriveKey(key []byte, purpose string) ([]byte, error) {
+	derived := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, key, nil, []byte(purpose))
+	if _, err := io.ReadFull(kdf, derived); err != nil {
+		return nil, fmt.Errorf("failed to derive %s key: %w", purpose, err)
+	}
+	return derived, nil
+}
+
+// associatedDataHeader serializes the secure type and the CryptoParams, using a length prefix for every value
+func associatedDataHeader(params CryptoParams, secureType reflect.Type) []byte {
+	values := []string{
+		"cryptostruct",
+		strconv.Itoa(params.Version),
+		secureType.PkgPath(),
+		secureType.Name(),
+		params.CipherSuite,
+		params.Nonce,
+		params.KeyID,
+		params.WrappedKey,
+	}
+	if k := params.KDF; k != nil {
+		values = append(values,
+			k.Name,
+			k.Salt,
+			strconv.FormatUint(uint64(k.Time), 10),
+			strconv.FormatUint(uint64(k.Memory), 10),
+			strconv.FormatUint(uint64(k.Threads), 10),
+			strconv.Itoa(k.N),
+			strconv.Itoa(k.R),
+			strconv.Itoa(k.P),
+		)
+	}
+	if params.Encoding != "" {
+		values = append(values, params.Encoding)
+	}
+	return serializeHeader(values)
+}
+
+// deterministicHeader serializes the secure type, the field name and the CryptoParams which identify the master key,
+// leaving out the nonce and the parameters which differ for every struct
+func deterministicHeader(params CryptoParams, secureType reflect.Type, key string, name string) []byte {
+	return serializeHeader([]string{
+		"cryptostruct deterministic",
+		strconv.Itoa(params.Version),
+		secureType.PkgPath(),
+		secureType.Name(),
+		params.CipherSuite,
+		params.KeyID,
+		key,
+		name,
+	})
+}
+
+// serializeHeader serializes values using a length prefix for every value
+func serializeHeader(values []string) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		b.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		b.WriteString(v)
+	}
+	return b.Bytes()
+}
+
+// associatedData returns the digest binding a ciphertext to the field at path.
+// Deterministic fields are bound to the field name in their header, not to their path, which contains slice indexes.
+func (c *cryptoContext) associatedData(path string) []byte {
+	h := sha256.New()
+	h.Write(c.header)
+	if c.siv == nil {
+		h.Write([]byte(path))
+	}
+	return h.Sum(nil)
+}
+
+// seal encrypts plaintext for the field at path
+func (c *cryptoContext) seal(plaintext []byte, path string) ([]byte, error) {
+	source := plaintext
+	if c.bound {
+		source = append(c.associatedData(path), plaintext...)
+	}
+
+	// Deterministic fields derive the random value of the ciphertext from the plaintext, bound to its field,
+	// so equal plaintexts produce equal ciphertexts and different plaintexts never share a random value
+	config := c.config
+	if c.siv != nil {
+		mac := hmac.New(sha256.New, c.siv)
+		mac.Write(source)
+		config.Rand = bytes.NewReader(mac.Sum(nil))
+	}
+
+	sourceDataReader := bytes.NewReader(source)
+	encryptedDataWriter := bytes.NewBuffer(make([]byte, 0, len(source)+64))
+
+	// Encrypt data from sourceDataReader into encryptedDataWriter using the crypto configuration
+	if _, err := sio.Encrypt(encryptedDataWriter, sourceDataReader, config); err != nil {
+		return nil, fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	return encryptedDataWriter.Bytes(), nil
+}
+
+// open decrypts ciphertext for the field at path, and verifies that it was created for that field
+func (c *cryptoContext) open(ciphertext []byte, path string) ([]byte, error) {
+	encryptedDataReader := bytes.NewReader(ciphertext)
+	decryptedDataWriter := bytes.NewBuffer(make([]byte, 0, len(ciphertext)))
+
+	// Decrypt data in encryptedDataReader into decryptedDataWriter using the crypto configuration
+	if _, err := sio.Decrypt(decryptedDataWriter, encryptedDataReader, c.config); err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %w: %w", ErrAuthentication, err)
+	}
+
+	plaintext := decryptedDataWriter.Bytes()
+	if !c.bound {
+		return plaintext, nil
+	}
+
+	if len(plaintext) < associatedDataSize || subtle.ConstantTimeCompare(plaintext[:associatedDataSize], c.associatedData(path)) != 1 {
+		return nil, ErrTampered
+	}
+	return plaintext[associatedDataSize:], nil
+}
+
+// encode encodes a ciphertext which is stored in a string
+func (c *cryptoContext) encode(ciphertext []byte) string {
+	switch c.encoding {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(ciphertext)
+	case EncodingBase64URL:
+		return base64.URLEncoding.EncodeToString(ciphertext)
+	default:
+		return hex.EncodeToString(ciphertext)
+	}
+}
+
+// decode decodes a ciphertext which is stored in a string
+func (c *cryptoContext) decode(s string) ([]byte, error) {
+	var (
+		err        error
+		ciphertext []byte
+	)
+
+	switch c.encoding {
+	case EncodingBase64:
+		ciphertext, err = base64.StdEncoding.DecodeString(s)
+	case EncodingBase64URL:
+		ciphertext, err = base64.URLEncoding.DecodeString(s)
+	default:
+		ciphertext, err = hex.DecodeString(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ciphertext: %w", err)
+	}
+	return ciphertext, nil
+}
+
+// fieldPath returns the path of field name in the struct at path
+func fieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// indexPath returns the path of element i in the slice at path
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+// mapKeyPath returns the path of the value for key in the map at path, key is always the plaintext key
+func mapKeyPath(path string, key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return path + "[" + strconv.Quote(key.String()) + "]"
+	}
+	return path + "[" + fmt.Sprint(key.Interface()) + "]"
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestEncryptedData(t *testing.T) testSecureData {
+	t.Helper()
+	encrypted, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), testData{}.GetTransformConfig()).Transform(newTestData())
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	return encrypted.(testSecureData)
+}
+
+func TestDecrypter_Transform_Tampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(d *testSecureData)
+	}{
+		{
+			name: "swapped fields",
+			tamper: func(d *testSecureData) {
+				d.Name, d.Title = d.Title, d.Name
+			},
+		},
+		{
+			name: "swapped slice elements",
+			tamper: func(d *testSecureData) {
+				d.NumberSlice[0], d.NumberSlice[1] = d.NumberSlice[1], d.NumberSlice[0]
+			},
+		},
+		{
+			name: "swapped nested structs",
+			tamper: func(d *testSecureData) {
+				d.SliceDetails[0], d.SliceDetails[1] = d.SliceDetails[1], d.SliceDetails[0]
+			},
+		},
+		{
+			name: "nested struct moved from field to slice",
+			tamper: func(d *testSecureData) {
+				d.SliceDetails[0] = d.Details
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := newTestEncryptedData(t)
+			tt.tamper(&encrypted)
+
+			_, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(encrypted)
+			if !errors.Is(err, ErrTampered) {
+				t.Errorf("Transform() error = %v, want %v", err, ErrTampered)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Transform_ModifiedCryptoParams(t *testing.T) {
+	encrypted := newTestEncryptedData(t)
+	encrypted.CryptoParams.Version = 0
+	if _, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(encrypted); err == nil {
+		t.Error("Transform() expected error for modified version")
+	}
+
+	// Nested structs use their own key, so ciphertexts copied between them cannot be decrypted
+	encrypted = newTestEncryptedData(t)
+	encrypted.Details.FirstName = encrypted.SliceDetails[0].FirstName
+	if _, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(encrypted); err == nil {
+		t.Error("Transform() expected error for ciphertext copied from another struct")
+	}
+}
+
+func TestDecrypter_Transform_DowngradedVersion(t *testing.T) {
+	input := testBytesData{Certificate: []byte("certificate"), Missing: []byte("private key")}
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	// Byte slices did not exist before version 1, so setting version 0 must not disable binding
+	encrypted := output.(testSecureBytesData)
+	encrypted.Certificate, encrypted.Missing = encrypted.Missing, encrypted.Certificate
+	encrypted.CryptoParams.Version = 0
+	if _, err = NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted); !errors.Is(err, ErrTampered) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrTampered)
+	}
+}
+
+func TestDecrypter_Transform_Unbound(t *testing.T) {
+	// Ciphertexts created before binding was introduced use version 0 and must still decrypt
+	params := newTestCryptoParams(t)
+	params.Version = 0
+	key, err := hex.DecodeString(testMasterKey)
+	if err != nil {
+		t.Fatalf("DecodeString() error = %v", err)
+	}
+	c, err := newCryptoContext(params, key, reflect.TypeOf(testSecureEmbeddedData{}))
+	if err != nil {
+		t.Fatalf("newCryptoContext() error = %v", err)
+	}
+
+	seal := func(value string) string {
+		source, err := convertValueToHexString(reflect.ValueOf(value))
+		if err != nil {
+			t.Fatalf("convertValueToHexString() error = %v", err)
+		}
+		encrypted, err := c.seal([]byte(source), "")
+		if err != nil {
+			t.Fatalf("seal() error = %v", err)
+		}
+		return hex.EncodeToString(encrypted)
+	}
+
+	encrypted := testSecureEmbeddedData{
+		FirstName:    seal("First"),
+		LastName:     seal("Last"),
+		CryptoParams: params,
+	}
+	decrypted, err := NewDecrypter(testMasterKey, testEmbeddedData{}.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if expected := (testEmbeddedData{FirstName: "First", LastName: "Last"}); decrypted != expected {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, expected)
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	if path := fieldPath(indexPath(fieldPath("", "SliceDetails"), 1), "FirstName"); path != "SliceDetails[1].FirstName" {
+		t.Errorf("path = %s", path)
+	}
+}
+
+func TestCryptoContext_FieldKey(t *testing.T) {
+	c, err := newCryptoContext(newTestCryptoParams(t), []byte("masterKey"), reflect.TypeOf(testSecureData{}))
+	if err != nil {
+		t.Fatalf("newCryptoContext() error = %v", err)
+	}
+	pii, err := c.field(Tag{Enabled: true, Key: "pii"}, "Name")
+	if err != nil {
+		t.Fatalf("field() error = %v", err)
+	}
+
+	ciphertext, err := pii.seal([]byte("secret"), "Name")
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if _, err = c.open(ciphertext, "Name"); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("open() with the key of the struct error = %v, want %v", err, ErrAuthentication)
+	}
+	if plaintext, err := pii.open(ciphertext, "Name"); err != nil || string(plaintext) != "secret" {
+		t.Errorf("open() = %q, %v", plaintext, err)
+	}
+}
+
+func TestDecrypter_Transform_HexPlaintext(t *testing.T) {
+	// Version 1 encrypted scalar values as hex encoded strings, which must still decrypt
+	params := newTestCryptoParams(t)
+	params.Version = 1
+	key, err := hex.DecodeString(testMasterKey)
+	if err != nil {
+		t.Fatalf("DecodeString() error = %v", err)
+	}
+	c, err := newCryptoContext(params, key, reflect.TypeOf(testSecureEmbeddedData{}))
+	if err != nil {
+		t.Fatalf("newCryptoContext() error = %v", err)
+	}
+
+	seal := func(value string, path string) string {
+		source, err := convertValueToHexString(reflect.ValueOf(value))
+		if err != nil {
+			t.Fatalf("convertValueToHexString() error = %v", err)
+		}
+		encrypted, err := c.seal([]byte(source), path)
+		if err != nil {
+			t.Fatalf("seal() error = %v", err)
+		}
+		return hex.EncodeToString(encrypted)
+	}
+
+	encrypted := testSecureEmbeddedData{
+		FirstName:    seal("First", "FirstName"),
+		LastName:     seal("Last", "LastName"),
+		CryptoParams: params,
+	}
+	decrypted, err := NewDecrypter(testMasterKey, testEmbeddedData{}.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if expected := (testEmbeddedData{FirstName: "First", LastName: "Last"}); decrypted != expected {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, expected)
+	}
+}
@@ -41,6 +41,7 @@ func NewCryptoParams(cipherSuite string) (CryptoParams, error) {
 	p = CryptoParams{
 		CipherSuite: cipherSuite,
 		Nonce:       nonce,
+		Version:     cryptoParamsVersion,
 	}
 
 	// Validate the cipherSuite
@@ -49,15 +50,44 @@ func NewCryptoParams(cipherSuite string) (CryptoParams, error) {
 }
 
 type CryptoParams struct {
-	CipherSuite string `json:"cipherSuite" yaml:"cipherSuite" mapstructure:"cipherSuite"`
-	Nonce       string `json:"nonce" yaml:"nonce" mapstructure:"nonce"`
+	CipherSuite string     `json:"cipherSuite" yaml:"cipherSuite" mapstructure:"cipherSuite"`
+	Nonce       string     `json:"nonce" yaml:"nonce" mapstructure:"nonce"`
+	KeyID       string     `json:"keyId,omitempty" yaml:"keyId,omitempty" mapstructure:"keyId"`
+	WrappedKey  string     `json:"wrappedKey,omitempty" yaml:"wrappedKey,omitempty" mapstructure:"wrappedKey"`
+	KDF         *KDFParams `json:"kdf,omitempty" yaml:"kdf,omitempty" mapstructure:"kdf"`
+	Version     int        `json:"version,omitempty" yaml:"version,omitempty" mapstructure:"version"`
+	MAC         string     `json:"mac,omitempty" yaml:"mac,omitempty" mapstructure:"mac"`
+	// KeyCheck identifies the key of the struct without revealing it, so a wrong key can be told apart from corrupted data
+	KeyCheck string `json:"keyCheck,omitempty" yaml:"keyCheck,omitempty" mapstructure:"keyCheck"`
+	// Encoding of the ciphertexts stored in strings, hex if empty. Fields can override it using their secure tag.
+	Encoding string `json:"encoding,omitempty" yaml:"encoding,omitempty" mapstructure:"encoding"`
+}
+
+// clearKeySource removes the information stored by a KeySource, before a KeySource records its own information
+func (p *CryptoParams) clearKeySource() {
+	p.KeyID = ""
+	p.WrappedKey = ""
+	p.KDF = nil
+}
+
+// renew returns a copy of p with a new nonce
+func (p CryptoParams) renew() (CryptoParams, error) {
+	var err error
+	if p.Nonce, err = createNonce(); err != nil {
+		return CryptoParams{}, err
+	}
+	return p, nil
 }
 
 func (p CryptoParams) getNonce() ([]byte, error) {
-	if p.Nonce != "" {
-		return hex.DecodeString(p.Nonce)
+	if p.Nonce == "" {
+		return nil, ErrMissingNonce
+	}
+	nonce, err := hex.DecodeString(p.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode nonce: %w", err)
 	}
-	return nil, fmt.Errorf("nonce is not set")
+	return nonce, nil
 }
 
 func createNonce() (string, error) {
@@ -76,23 +106,30 @@ func (p CryptoParams) getCipherSuite() ([]byte, error) {
 	case "CHACHA20_POLY1305":
 		return []byte{sio.CHACHA20_POLY1305}, nil
 	default:
-		return nil, fmt.Errorf("invalid cipher suite %s", p.CipherSuite)
+		return nil, fmt.Errorf("%w %s", ErrInvalidCipherSuite, p.CipherSuite)
 	}
 }
 
 func (p CryptoParams) GetCryptoConfig(masterKeyHex string) (sio.Config, error) {
 	var (
-		err          error
-		masterKey    []byte
-		nonce        []byte
-		key          [32]byte
-		cipherSuites []byte
+		err       error
+		masterKey []byte
 	)
 
 	masterKey, err = hex.DecodeString(masterKeyHex)
 	if err != nil {
 		return sio.Config{}, fmt.Errorf("could not decode masterKeyHex key: %w", err)
 	}
+	return p.getCryptoConfig(masterKey)
+}
+
+func (p CryptoParams) getCryptoConfig(masterKey []byte) (sio.Config, error) {
+	var (
+		err          error
+		nonce        []byte
+		key          [32]byte
+		cipherSuites []byte
+	)
 
 	nonce, err = p.getNonce()
 	if err != nil {
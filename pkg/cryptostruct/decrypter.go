@@ -17,166 +17,412 @@
 package cryptostruct
 
 import (
-	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"reflect"
-
-	"github.com/minio/sio"
 )
 
+// Decrypt decrypts data using the bytes of key as master key.
 func Decrypt(key string, data DecryptTransformer) (any, error) {
 	return NewDecrypter(hex.EncodeToString([]byte(key)), data.GetTransformConfig()).Transform(data)
 }
 
-func NewDecrypter(masterKeyHex string, c TransformConfig) Decrypter {
+// DecryptWithPassphrase decrypts data which was encrypted using a master key derived from passphrase.
+// The key derivation function and its parameters are taken from the CryptoParams of data.
+func DecryptWithPassphrase(passphrase string, data DecryptTransformer) (any, error) {
+	var (
+		err  error
+		keys KeySource
+	)
+	if keys, err = NewPassphrase(passphrase, DefaultArgon2idParams()); err != nil {
+		return nil, err
+	}
+	return NewDecrypterWithKeySource(keys, data.GetTransformConfig()).Transform(data)
+}
+
+func NewDecrypter(masterKeyHex string, c TransformConfig, opts ...Option) Decrypter {
+	return NewDecrypterWithKeySource(masterKey(masterKeyHex), c, opts...)
+}
+
+// NewDecrypterWithKeySource returns a Decrypter which gets the master key from the KeySource, e.g. a Keyring.
+func NewDecrypterWithKeySource(k KeySource, c TransformConfig, opts ...Option) Decrypter {
 	return Decrypter{
-		key:    masterKeyHex,
-		config: c,
+		keys:    k,
+		config:  c,
+		options: newOptions(opts),
 	}
 }
 
 type Decrypter struct {
-	key    string
-	config TransformConfig
+	keys    KeySource
+	config  TransformConfig
+	options options
 }
 
+// Transform decrypts r, which can be a struct or a pointer to a struct.
+// A pointer is returned when the decrypted type in the TransformConfig is a pointer.
 func (t Decrypter) Transform(r DecryptTransformer) (any, error) {
 	var (
-		err          error
-		tags         map[string]Tag
-		output       any
-		cryptoConfig sio.Config
+		err        error
+		plan       *structPlan
+		inputValue reflect.Value
+		output     reflect.Value
 	)
 
-	// Get input type and value of r
-	inputType := reflect.TypeOf(r)
-	inputValue := reflect.ValueOf(r)
+	if inputValue, err = structValue(r); err != nil {
+		return nil, err
+	}
+	outputType, asPointer := structType(reflect.TypeOf(t.config.Decrypted))
+	if outputType == nil {
+		return nil, fmt.Errorf("transform config does not declare the decrypted type")
+	}
 
-	// // Get CryptoParams from input
-	cryptoConfig, err = r.GetCryptoParams().GetCryptoConfig(t.key)
-	if err != nil {
-		return nil, fmt.Errorf("could not initialize crypto parameters: %w", err)
+	// Get the plan to convert the type of r into the decrypted type
+	if plan, err = getPlan(inputValue.Type(), outputType, decryptMode); err != nil {
+		return nil, err
 	}
 
-	// Get the struct tags for r
-	tags, err = getTags(r)
-	if err != nil {
-		return nil, fmt.Errorf("could not get tags: %w", err)
+	// When errors are collected, the partially decrypted output is returned together with the errors
+	if output, err = t.transform(plan, inputValue, r.GetCryptoParams(), ""); !output.IsValid() {
+		return nil, err
+	}
+	if asPointer {
+		return output.Addr().Interface(), err
 	}
+	return output.Interface(), err
+}
 
-	// Get struct to which data must be converted
-	output = t.config.Decrypted
+// transform decrypts the struct inputValue, path is the location of the struct within the top-level struct
+func (t Decrypter) transform(plan *structPlan, inputValue reflect.Value, params CryptoParams, path string) (reflect.Value, error) {
+	var (
+		err error
+		key []byte
+		c   *cryptoContext
+	)
 
-	// To be able to set the fields for the output, we need to get the Value of the output pointer
-	outputValue := reflect.ValueOf(&output).Elem()
-	// To be able to mutate the outputValue, we must first get a temporary outputValue
-	tmp := reflect.New(outputValue.Elem().Type()).Elem()
-	tmp.Set(outputValue.Elem())
+	if t.options.strict {
+		if err = plan.checkStrict(); err != nil {
+			return reflect.Value{}, newFieldError(path, err)
+		}
+	}
 
-	// Process all fields in r
-	for i := 0; i < inputValue.NumField(); i++ {
-		fieldName := inputType.Field(i).Name
-		fieldType := inputType.Field(i).Type
-		fieldValue := inputValue.Field(i)
+	// Get the master key for the CryptoParams of the input
+	if key, err = t.keys.decryptionKey(params); err != nil {
+		return reflect.Value{}, newFieldError(path, fmt.Errorf("could not get decryption key: %w", err))
+	}
 
-		// CryptoParams must not be stored in the output
-		if fieldType == reflect.TypeOf(CryptoParams{}) {
-			continue
+	if c, err = newCryptoContext(params, key, plan.input); err != nil {
+		return reflect.Value{}, newFieldError(path, err)
+	}
+	// Data encrypted before the key check was introduced reports a wrong key as ErrAuthentication
+	if params.KeyCheck != "" {
+		if err = c.verifyKeyCheck(params.KeyCheck); err != nil {
+			return reflect.Value{}, newFieldError(path, fmt.Errorf("struct %s: %w", plan.input, err))
+		}
+	}
+	// The version is stored with the data, it must not be able to disable binding for fields which are always bound
+	if !c.bound && plan.requiresBinding {
+		return reflect.Value{}, newFieldError(path, fmt.Errorf("struct %s has version %d, but its fields are always bound: %w", plan.input, params.Version, ErrTampered))
+	}
+
+	// Verify the integrity of all fields before decrypting
+	if params.MAC != "" {
+		if err = c.verifyIntegrity(inputValue, plan.cryptoParamsIndex, params, path); err != nil {
+			return reflect.Value{}, err
 		}
+	} else if t.options.integrity {
+		return reflect.Value{}, newFieldError(path, fmt.Errorf("struct %s has no MAC: %w", plan.input, ErrIntegrity))
+	}
+
+	output := reflect.New(plan.output).Elem()
+
+	// Process all fields in the input
+	var errs []error
+	for _, field := range plan.fields {
+		fieldValue := inputValue.FieldByIndex(field.inputIndex)
 
 		// If field tag is not enabled, copy the value to the output
-		if !tags[fieldName].Enabled {
-			tmp.FieldByName(fieldName).Set(fieldValue)
+		if field.value == nil {
+			output.FieldByIndex(field.outputIndex).Set(fieldValue)
+			continue
+		}
+		// An empty field with omitempty was the zero value
+		if field.tag.OmitEmpty && fieldValue.IsZero() {
 			continue
 		}
 
 		// Decrypt current field
-		var decryptedValue reflect.Value
-		switch inputValue.Field(i).Kind() {
-		case reflect.Slice:
-			if decryptedValue, err = t.decryptSlice(fieldValue, tmp.FieldByName(fieldName).Type(), cryptoConfig); err != nil {
-				return nil, err
-			}
-		default:
-			if decryptedValue, err = t.decryptFields(fieldType, fieldValue, tmp.FieldByName(fieldName).Kind(), cryptoConfig); err != nil {
-				return nil, err
+		var (
+			decryptedValue reflect.Value
+			fieldContext   *cryptoContext
+		)
+		if fieldContext, err = c.field(field.tag, field.name); err != nil {
+			err = newFieldError(fieldPath(path, field.name), err)
+		} else {
+			decryptedValue, err = t.decryptValue(field.value, fieldValue, fieldContext, fieldPath(path, field.name))
+		}
+		if err != nil {
+			if errs, err = t.collectError(errs, err); err != nil {
+				return reflect.Value{}, err
 			}
 		}
-		tmp.FieldByName(fieldName).Set(decryptedValue)
+		// A field which failed entirely keeps its zero value
+		if decryptedValue.IsValid() {
+			output.FieldByIndex(field.outputIndex).Set(decryptedValue)
+		}
 	}
+	return output, errors.Join(errs...)
+}
 
-	outputValue.Set(tmp)
-	return output, nil
+// collectError adds err to errs when the Decrypter collects errors, otherwise err is returned to abort decrypting.
+// A partially decrypted value comes with the errors of its failed parts, which are added one by one.
+func (t Decrypter) collectError(errs []error, err error) ([]error, error) {
+	if !t.options.collectErrors {
+		return errs, err
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return append(errs, joined.Unwrap()...), nil
+	}
+	return append(errs, err), nil
 }
 
-func (t Decrypter) decryptSlice(inputValue reflect.Value, outputType reflect.Type, cryptoConfig sio.Config) (reflect.Value, error) {
+func (t Decrypter) decryptValue(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	switch plan.op {
+	case opSlice:
+		return t.decryptSlice(plan, inputValue, c, path)
+	case opBytes:
+		return t.decryptBytes(plan, inputValue, c, path)
+	case opMarshaler:
+		return t.decryptMarshaler(plan, inputValue, c, path)
+	case opInterface:
+		return t.decryptInterface(plan, inputValue, c, path)
+	case opArray:
+		return t.decryptArray(plan, inputValue, c, path)
+	case opMap:
+		return t.decryptMap(plan, inputValue, c, path)
+	case opPointer:
+		return t.decryptPointer(plan, inputValue, c, path)
+	case opTransformer:
+		return t.decryptStruct(plan, inputValue, path)
+	default:
+		return t.decryptFields(inputValue, plan.output, c, path)
+	}
+}
+
+func (t Decrypter) decryptSlice(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
 	var (
 		err    error
 		output reflect.Value
 	)
-	// Create a slice of the outputType with the correct capacity
-	output = reflect.MakeSlice(outputType, 0, 0)
+	// Create a slice of the output type with the correct capacity
+	output = reflect.MakeSlice(plan.output, 0, inputValue.Len())
 
-	// Loop over the input slice and encrypt each element
+	// Loop over the input slice and decrypt each element
+	var errs []error
 	for i := 0; i < inputValue.Len(); i++ {
 		var decryptedValue reflect.Value
-		if decryptedValue, err = t.decryptFields(reflect.TypeOf(inputValue.Index(i).Interface()), inputValue.Index(i), outputType.Elem().Kind(), cryptoConfig); err != nil {
-			return reflect.Value{}, err
+		if decryptedValue, err = t.decryptValue(plan.elem, inputValue.Index(i), c, indexPath(path, i)); err != nil {
+			if errs, err = t.collectError(errs, err); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		// Append the decrypted value to the output, an element which failed entirely keeps its zero value
+		if !decryptedValue.IsValid() {
+			decryptedValue = reflect.Zero(plan.elem.output)
 		}
-		// Append the decrypted value to the output
 		output = reflect.Append(output, decryptedValue)
 	}
-	return output, nil
+	return output, errors.Join(errs...)
 }
 
-func (t Decrypter) decryptFields(fieldType reflect.Type, fieldValue reflect.Value, outputKind reflect.Kind, cryptoConfig sio.Config) (reflect.Value, error) {
-	var (
-		err error
-		out reflect.Value
-	)
-
-	// Check if the fieldType implements interface DecryptTransformer
-	if fieldType.Implements(reflect.TypeOf((*DecryptTransformer)(nil)).Elem()) {
-		if out, err = t.decryptStruct(fieldValue); err != nil {
-			return reflect.Value{}, err
-		}
-	} else {
-		// Decode fieldValue from hex encoded string to []byte
-		var source []byte
-		source, err = hex.DecodeString(fieldValue.String())
+func (t Decrypter) decryptArray(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// The length of both array types is validated when the plan is compiled
+	output := reflect.New(plan.output).Elem()
+	var errs []error
+	for i := 0; i < inputValue.Len(); i++ {
+		decryptedValue, err := t.decryptValue(plan.elem, inputValue.Index(i), c, indexPath(path, i))
 		if err != nil {
-			return reflect.Value{}, err
+			if errs, err = t.collectError(errs, err); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if decryptedValue.IsValid() {
+			output.Index(i).Set(decryptedValue)
 		}
+	}
+	return output, errors.Join(errs...)
+}
 
-		encryptedDataReader := bytes.NewReader(source)
-		decryptedDataWriter := bytes.NewBuffer(make([]byte, 0))
+func (t Decrypter) decryptMap(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	var err error
 
-		// Decrypt data in encryptedDataReader into decryptedDataWriter using cryptoconfig
-		if _, err = sio.Decrypt(decryptedDataWriter, encryptedDataReader, cryptoConfig); err != nil {
-			return reflect.Value{}, fmt.Errorf("failed to decrypt data: %w", err)
+	// Keep nil maps nil
+	if inputValue.IsNil() {
+		return reflect.Zero(plan.output), nil
+	}
+
+	output := reflect.MakeMapWithSize(plan.output, inputValue.Len())
+	iter := inputValue.MapRange()
+	var errs []error
+	for iter.Next() {
+		var decryptedKey, decryptedValue reflect.Value
+
+		// An entry of which the key cannot be decrypted is left out
+		decryptedKey = iter.Key()
+		if plan.key != nil {
+			if decryptedKey, err = t.decryptValue(plan.key, iter.Key(), c, path); err != nil {
+				if errs, err = t.collectError(errs, err); err != nil {
+					return reflect.Value{}, err
+				}
+				continue
+			}
+			// Two encrypted keys can only decrypt to the same key if a ciphertext was duplicated
+			if output.MapIndex(decryptedKey).IsValid() {
+				err = &FieldError{Path: mapKeyPath(path, decryptedKey), Err: fmt.Errorf("duplicate map key: %w", ErrTampered)}
+				if errs, err = t.collectError(errs, err); err != nil {
+					return reflect.Value{}, err
+				}
+				continue
+			}
+		}
+		if decryptedValue, err = t.decryptValue(plan.elem, iter.Value(), c, mapKeyPath(path, decryptedKey)); err != nil {
+			if errs, err = t.collectError(errs, err); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if !decryptedValue.IsValid() {
+			decryptedValue = reflect.Zero(plan.elem.output)
 		}
+		output.SetMapIndex(decryptedKey, decryptedValue)
+	}
+	return output, errors.Join(errs...)
+}
 
-		// Convert decrypted data from hex string to desired output type
-		out, err = convertHexStringToValue(decryptedDataWriter.String(), outputKind)
-		if err != nil {
-			return reflect.Value{}, err
+func (t Decrypter) decryptPointer(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// Keep nil pointers nil, instead of decrypting the zero value
+	if inputValue.IsNil() {
+		return reflect.Zero(plan.output), nil
+	}
+
+	// A partially decrypted value is returned together with its errors
+	value, err := t.decryptValue(plan.elem, inputValue.Elem(), c, path)
+	if !value.IsValid() {
+		return reflect.Value{}, err
+	}
+	output := reflect.New(plan.output.Elem())
+	output.Elem().Set(value)
+	return output, err
+}
+
+func (t Decrypter) decryptBytes(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// An empty value was a nil byte slice
+	if inputValue.Len() == 0 {
+		return reflect.Zero(plan.output), nil
+	}
+
+	decrypted, err := t.decryptBlob(inputValue, c, path)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(decrypted).Convert(plan.output), nil
+}
+
+func (t Decrypter) decryptMarshaler(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	decrypted, err := t.decryptBlob(inputValue, c, path)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	output, err := unmarshalValue(decrypted, plan.output, plan.text)
+	if err != nil {
+		return reflect.Value{}, &FieldError{Path: path, Err: fmt.Errorf("could not unmarshal %s: %w", plan.output, err)}
+	}
+	return output, nil
+}
+
+func (t Decrypter) decryptInterface(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// An empty value was a nil interface
+	if inputValue.Len() == 0 {
+		return reflect.Zero(plan.output), nil
+	}
+
+	decrypted, err := t.decryptBlob(inputValue, c, path)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	value, err := unmarshalRegistered(decrypted, c.rawPlaintext)
+	if err != nil {
+		return reflect.Value{}, &FieldError{Path: path, Err: err}
+	}
+	if !value.Type().Implements(plan.output) {
+		return reflect.Value{}, &FieldError{Path: path, Err: fmt.Errorf("registered type %s does not implement %s", value.Type(), plan.output)}
+	}
+
+	output := reflect.New(plan.output).Elem()
+	output.Set(value)
+	return output, nil
+}
+
+// decryptBlob decrypts a value which was encrypted as a single value
+func (t Decrypter) decryptBlob(inputValue reflect.Value, c *cryptoContext, path string) ([]byte, error) {
+	var (
+		err    error
+		source []byte
+	)
+
+	// Decode strings, byte slices hold the encrypted bytes as-is
+	if inputValue.Kind() == reflect.String {
+		if source, err = c.decode(inputValue.String()); err != nil {
+			return nil, &FieldError{Path: path, Err: err}
 		}
+	} else {
+		source = inputValue.Bytes()
 	}
-	return out, nil
+
+	decrypted, err := c.open(source, path)
+	if err != nil {
+		return nil, &FieldError{Path: path, Err: err}
+	}
+	return decrypted, nil
 }
 
-func (t Decrypter) decryptStruct(field reflect.Value) (reflect.Value, error) {
+func (t Decrypter) decryptFields(fieldValue reflect.Value, outputType reflect.Type, c *cryptoContext, path string) (reflect.Value, error) {
 	var (
 		err       error
-		decrypter Decrypter
-		output    any
+		decrypted []byte
+		output    reflect.Value
 	)
 
-	decrypter = NewDecrypter(t.key, getEmbeddedTransformConfig(field))
+	// Decrypt the data and verify it belongs to the path of the field
+	if decrypted, err = t.decryptBlob(fieldValue, c, path); err != nil {
+		return reflect.Value{}, err
+	}
+
+	// Convert decrypted data to desired output type, it was hex encoded before version 2
+	if c.rawPlaintext {
+		output, err = convertBytesToValue(decrypted, outputType)
+	} else {
+		output, err = convertHexStringToValue(string(decrypted), outputType)
+	}
+	if err != nil {
+		return reflect.Value{}, &FieldError{Path: path, Err: err}
+	}
+	return output, nil
+}
+
+func (t Decrypter) decryptStruct(plan *valuePlan, field reflect.Value, path string) (reflect.Value, error) {
+	var (
+		err        error
+		nestedPlan *structPlan
+	)
 
-	if output, err = decrypter.Transform(field.Interface().(DecryptTransformer)); err != nil {
+	if nestedPlan, err = getPlan(plan.input, plan.output, decryptMode); err != nil {
 		return reflect.Value{}, err
 	}
-	return reflect.ValueOf(output), nil
+
+	// Every nested struct is decrypted using its own CryptoParams
+	params := field.Field(nestedPlan.cryptoParamsIndex).Interface().(CryptoParams)
+	return t.transform(nestedPlan, field, params, path)
 }
@@ -16,4 +16,581 @@
 
 package cryptostruct
 
-// TODO Add tests
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testMapData struct {
+	Labels  map[string]string           `secure:"true"`
+	Ports   map[int]int                 `secure:"true"`
+	Details map[string]testEmbeddedData `secure:"true"`
+	Empty   map[string]string           `secure:"true"`
+}
+
+func (d testMapData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testMapData{},
+		Encrypted: testSecureMapData{},
+	}
+}
+
+type testSecureMapData struct {
+	Labels       map[string]string                 `secure:"true"`
+	Ports        map[string]string                 `secure:"true"`
+	Details      map[string]testSecureEmbeddedData `secure:"true"`
+	Empty        map[string]string                 `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureMapData) GetTransformConfig() TransformConfig {
+	return testMapData{}.GetTransformConfig()
+}
+
+func (d testSecureMapData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testPointerData struct {
+	Name         *string             `secure:"true"`
+	Count        *int                `secure:"true"`
+	Missing      *string             `secure:"true"`
+	Details      *testEmbeddedData   `secure:"true"`
+	NilDetails   *testEmbeddedData   `secure:"true"`
+	SliceDetails []*testEmbeddedData `secure:"true"`
+}
+
+func (d testPointerData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testPointerData{},
+		Encrypted: testSecurePointerData{},
+	}
+}
+
+type testSecurePointerData struct {
+	Name         *string                   `secure:"true"`
+	Count        *string                   `secure:"true"`
+	Missing      *string                   `secure:"true"`
+	Details      *testSecureEmbeddedData   `secure:"true"`
+	NilDetails   *testSecureEmbeddedData   `secure:"true"`
+	SliceDetails []*testSecureEmbeddedData `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecurePointerData) GetTransformConfig() TransformConfig {
+	return testPointerData{}.GetTransformConfig()
+}
+
+func (d testSecurePointerData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func newTestPointerData() testPointerData {
+	name := "insecuredata"
+	count := 42
+	return testPointerData{
+		Name:    &name,
+		Count:   &count,
+		Details: &testEmbeddedData{FirstName: "First", LastName: "Last"},
+		SliceDetails: []*testEmbeddedData{
+			{FirstName: "Slice1-First", LastName: "Slice1-Last"},
+			nil,
+		},
+	}
+}
+
+type testArrayData struct {
+	Numbers [4]int              `secure:"true"`
+	Details [2]testEmbeddedData `secure:"true"`
+}
+
+func (d testArrayData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testArrayData{},
+		Encrypted: testSecureArrayData{},
+	}
+}
+
+type testSecureArrayData struct {
+	Numbers      [4]string                 `secure:"true"`
+	Details      [2]testSecureEmbeddedData `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureArrayData) GetTransformConfig() TransformConfig {
+	return testArrayData{}.GetTransformConfig()
+}
+
+func (d testSecureArrayData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testBytes []byte
+
+type testBytesData struct {
+	Certificate []byte    `secure:"true"`
+	Key         testBytes `secure:"true"`
+	Missing     []byte    `secure:"true"`
+	Chain       [][]byte  `secure:"true"`
+}
+
+func (d testBytesData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testBytesData{},
+		Encrypted: testSecureBytesData{},
+	}
+}
+
+type testSecureBytesData struct {
+	Certificate  string   `secure:"true"`
+	Key          []byte   `secure:"true"`
+	Missing      string   `secure:"true"`
+	Chain        []string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureBytesData) GetTransformConfig() TransformConfig {
+	return testBytesData{}.GetTransformConfig()
+}
+
+func (d testSecureBytesData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testMarshalerData struct {
+	Expiry   time.Time     `secure:"true"`
+	Created  time.Time     `secure:"true"`
+	Timeout  time.Duration `secure:"true"`
+	Address  net.IP        `secure:"true"`
+	Endpoint url.URL       `secure:"true"`
+	Serial   big.Int       `secure:"true"`
+	Previous *time.Time    `secure:"true"`
+}
+
+func (d testMarshalerData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testMarshalerData{},
+		Encrypted: testSecureMarshalerData{},
+	}
+}
+
+type testSecureMarshalerData struct {
+	Expiry       string  `secure:"true"`
+	Created      []byte  `secure:"true"`
+	Timeout      string  `secure:"true"`
+	Address      string  `secure:"true"`
+	Endpoint     string  `secure:"true"`
+	Serial       string  `secure:"true"`
+	Previous     *string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureMarshalerData) GetTransformConfig() TransformConfig {
+	return testMarshalerData{}.GetTransformConfig()
+}
+
+func (d testSecureMarshalerData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+// EmbeddedCredentials is exported, as embedded fields with an unexported type can only be flattened
+type EmbeddedCredentials struct {
+	Username string `secure:"true"`
+	Password string `secure:"true"`
+}
+
+func (d EmbeddedCredentials) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: EmbeddedCredentials{},
+		Encrypted: SecureEmbeddedCredentials{},
+	}
+}
+
+type SecureEmbeddedCredentials struct {
+	Username     string `secure:"true"`
+	Password     string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d SecureEmbeddedCredentials) GetTransformConfig() TransformConfig {
+	return EmbeddedCredentials{}.GetTransformConfig()
+}
+
+func (d SecureEmbeddedCredentials) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testAddress struct {
+	City string `secure:"true"`
+}
+
+// testFlattenedService embeds structs without secure tag, which are flattened
+type testFlattenedService struct {
+	EmbeddedCredentials
+	testAddress
+	Host string `secure:"true"`
+	Port int    `secure:"false"`
+}
+
+func (d testFlattenedService) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testFlattenedService{},
+		Encrypted: testSecureFlattenedService{},
+	}
+}
+
+type testSecureFlattenedService struct {
+	Username     string `secure:"true"`
+	Password     string `secure:"true"`
+	City         string `secure:"true"`
+	Host         string `secure:"true"`
+	Port         int    `secure:"false"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureFlattenedService) GetTransformConfig() TransformConfig {
+	return testFlattenedService{}.GetTransformConfig()
+}
+
+func (d testSecureFlattenedService) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+// testNestedService embeds a transformer with secure tag, which is encrypted into its secure twin
+type testNestedService struct {
+	EmbeddedCredentials `secure:"true"`
+	Host                string `secure:"true"`
+}
+
+func (d testNestedService) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testNestedService{},
+		Encrypted: testSecureNestedService{},
+	}
+}
+
+type testSecureNestedService struct {
+	SecureEmbeddedCredentials `secure:"true"`
+	Host                      string `secure:"true"`
+	CryptoParams              CryptoParams
+}
+
+func (d testSecureNestedService) GetTransformConfig() TransformConfig {
+	return testNestedService{}.GetTransformConfig()
+}
+
+func (d testSecureNestedService) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func newTestMapData() testMapData {
+	return testMapData{
+		Labels: map[string]string{"env": "production", "team": "platform"},
+		Ports:  map[int]int{80: 8080, 443: 8443},
+		Details: map[string]testEmbeddedData{
+			"owner": {FirstName: "First", LastName: "Last", Details: testSecondEmbeddedData{Age: 42}},
+		},
+	}
+}
+
+func TestDecrypter_Transform(t *testing.T) {
+	for _, cipherSuite := range []string{"AES_256_GCM", "CHACHA20_POLY1305"} {
+		t.Run(cipherSuite, func(t *testing.T) {
+			input := newTestData()
+			params, err := NewCryptoParams(cipherSuite)
+			if err != nil {
+				t.Fatalf("NewCryptoParams() error = %v", err)
+			}
+
+			encrypted, err := NewEncrypter(testMasterKey, params, input.GetTransformConfig()).Transform(input)
+			if err != nil {
+				t.Fatalf("Encrypter.Transform() error = %v", err)
+			}
+
+			decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted.(testSecureData))
+			if err != nil {
+				t.Fatalf("Decrypter.Transform() error = %v", err)
+			}
+			if !reflect.DeepEqual(decrypted, input) {
+				t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Transform_WrongKey(t *testing.T) {
+	input := newTestData()
+	encrypted, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	wrongKey := hex.EncodeToString([]byte("wrongKey"))
+	if _, err = NewDecrypter(wrongKey, input.GetTransformConfig()).Transform(encrypted.(testSecureData)); err == nil {
+		t.Error("Transform() expected error for wrong key")
+	}
+}
+
+func TestDecrypter_Transform_Map(t *testing.T) {
+	input := newTestMapData()
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	encrypted := output.(testSecureMapData)
+	if encrypted.Labels["env"] == "" || encrypted.Labels["env"] == input.Labels["env"] {
+		t.Errorf("Labels were not encrypted: %v", encrypted.Labels)
+	}
+	// Keys are encrypted because the key type differs between both types
+	if _, ok := encrypted.Ports["80"]; ok || len(encrypted.Ports) != len(input.Ports) {
+		t.Errorf("Ports keys were not encrypted: %v", encrypted.Ports)
+	}
+	if encrypted.Empty != nil {
+		t.Errorf("Empty = %v, want nil", encrypted.Empty)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestDecrypter_Transform_MapSwappedValues(t *testing.T) {
+	input := newTestMapData()
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	// Values are bound to their key
+	encrypted := output.(testSecureMapData)
+	encrypted.Labels["env"], encrypted.Labels["team"] = encrypted.Labels["team"], encrypted.Labels["env"]
+	if _, err = NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted); !errors.Is(err, ErrTampered) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrTampered)
+	}
+}
+
+func TestDecrypter_Transform_Pointer(t *testing.T) {
+	input := newTestPointerData()
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	encrypted := output.(testSecurePointerData)
+	if encrypted.Name == nil || *encrypted.Name == *input.Name {
+		t.Errorf("Name was not encrypted: %v", encrypted.Name)
+	}
+	if encrypted.Missing != nil || encrypted.NilDetails != nil || encrypted.SliceDetails[1] != nil {
+		t.Errorf("nil pointers were not preserved: %+v", encrypted)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestDecrypter_Transform_Array(t *testing.T) {
+	input := testArrayData{
+		Numbers: [4]int{1, 2, 3, 4},
+		Details: [2]testEmbeddedData{{FirstName: "First"}, {LastName: "Last"}},
+	}
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	encrypted := output.(testSecureArrayData)
+	if encrypted.Numbers[0] == "" || encrypted.Details[1].LastName == "" {
+		t.Errorf("arrays were not encrypted: %+v", encrypted)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestDecrypter_Transform_Bytes(t *testing.T) {
+	input := testBytesData{
+		Certificate: make([]byte, 1024),
+		Key:         testBytes("private key"),
+		Chain:       [][]byte{[]byte("first"), {}},
+	}
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	// Byte slices are encrypted as a single value, adding a single header and tag
+	encrypted := output.(testSecureBytesData)
+	if len(encrypted.Certificate) > 2*(len(input.Certificate)+256) {
+		t.Errorf("Certificate was not encrypted as a single value, length = %d", len(encrypted.Certificate))
+	}
+	if len(encrypted.Key) == 0 || string(encrypted.Key) == string(input.Key) {
+		t.Errorf("Key was not encrypted: %x", encrypted.Key)
+	}
+	if encrypted.Missing != "" {
+		t.Errorf("Missing = %q, want empty string for nil byte slice", encrypted.Missing)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestDecrypter_Transform_Marshaler(t *testing.T) {
+	endpoint, err := url.Parse("https://user@example.com:8443/api?version=2")
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	previous := time.Date(2023, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	input := testMarshalerData{
+		Expiry:   time.Date(2025, 1, 31, 23, 59, 59, 999, time.UTC),
+		Created:  time.Date(2024, 2, 29, 8, 30, 0, 0, time.UTC),
+		Timeout:  90 * time.Second,
+		Address:  net.ParseIP("192.0.2.10"),
+		Endpoint: *endpoint,
+		Previous: &previous,
+	}
+	input.Serial.SetString("123456789012345678901234567890", 10)
+
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(output.(testSecureMarshalerData))
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+
+	result := decrypted.(testMarshalerData)
+	if !result.Expiry.Equal(input.Expiry) || !result.Created.Equal(input.Created) || !result.Previous.Equal(*input.Previous) {
+		t.Errorf("times = %v, %v, %v, want %v, %v, %v", result.Expiry, result.Created, result.Previous, input.Expiry, input.Created, input.Previous)
+	}
+	if result.Timeout != input.Timeout {
+		t.Errorf("Timeout = %v, want %v", result.Timeout, input.Timeout)
+	}
+	if !result.Address.Equal(input.Address) {
+		t.Errorf("Address = %v, want %v", result.Address, input.Address)
+	}
+	if result.Endpoint.String() != input.Endpoint.String() {
+		t.Errorf("Endpoint = %v, want %v", result.Endpoint.String(), input.Endpoint.String())
+	}
+	if result.Serial.Cmp(&input.Serial) != 0 {
+		t.Errorf("Serial = %v, want %v", result.Serial.String(), input.Serial.String())
+	}
+}
+
+func TestDecrypter_Transform_Embedded(t *testing.T) {
+	credentials := EmbeddedCredentials{Username: "user", Password: "password"}
+	tests := []struct {
+		name  string
+		input EncryptTransformer
+		check func(t *testing.T, encrypted any)
+	}{
+		{
+			name: "flattened",
+			input: testFlattenedService{
+				EmbeddedCredentials: credentials,
+				testAddress:         testAddress{City: "Antwerp"},
+				Host:                "localhost",
+				Port:                443,
+			},
+			check: func(t *testing.T, encrypted any) {
+				e := encrypted.(testSecureFlattenedService)
+				if e.Username == "" || e.Username == credentials.Username || e.City == "" || e.Port != 443 {
+					t.Errorf("flattened fields were not encrypted: %+v", e)
+				}
+			},
+		},
+		{
+			name:  "nested",
+			input: testNestedService{EmbeddedCredentials: credentials, Host: "localhost"},
+			check: func(t *testing.T, encrypted any) {
+				e := encrypted.(testSecureNestedService)
+				if e.SecureEmbeddedCredentials.Username == "" || e.SecureEmbeddedCredentials.CryptoParams.Nonce == e.CryptoParams.Nonce {
+					t.Errorf("embedded struct was not encrypted using its own CryptoParams: %+v", e)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), tt.input.GetTransformConfig()).Transform(tt.input)
+			if err != nil {
+				t.Fatalf("Encrypter.Transform() error = %v", err)
+			}
+			tt.check(t, encrypted)
+
+			decrypted, err := NewDecrypter(testMasterKey, tt.input.GetTransformConfig()).Transform(encrypted.(DecryptTransformer))
+			if err != nil {
+				t.Fatalf("Decrypter.Transform() error = %v", err)
+			}
+			if !reflect.DeepEqual(decrypted, tt.input) {
+				t.Errorf("Transform() = %+v, want %+v", decrypted, tt.input)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Transform_CollectErrors(t *testing.T) {
+	encrypted := newTestEncryptedData(t)
+	encrypted.Title = "00"
+	encrypted.Details.CryptoParams.Nonce = ""
+	encrypted.SliceDetails[1].FirstName = encrypted.SliceDetails[0].FirstName
+	encrypted.NumberSlice[2] = encrypted.NumberSlice[3]
+
+	if output, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(encrypted); output != nil || err == nil {
+		t.Fatalf("Transform() = %v, %v, want nil and an error without WithCollectErrors", output, err)
+	}
+
+	output, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig(), WithCollectErrors()).Transform(encrypted)
+	if err == nil {
+		t.Fatal("Transform() expected error")
+	}
+
+	var paths []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var fieldErr *FieldError
+		if !errors.As(e, &fieldErr) {
+			t.Fatalf("error %v is not a FieldError", e)
+		}
+		paths = append(paths, fieldErr.Path)
+	}
+	expectedPaths := []string{"Title", "Details", "SliceDetails[1].FirstName", "NumberSlice[2]"}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("error paths = %v, want %v", paths, expectedPaths)
+	}
+
+	expected := newTestData()
+	expected.Title = ""
+	expected.Details = testEmbeddedData{}
+	expected.SliceDetails[1].FirstName = ""
+	expected.NumberSlice[2] = 0
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("Transform() = %+v, want %+v", output, expected)
+	}
+}
@@ -17,105 +17,193 @@
 package cryptostruct
 
 import (
-	"bytes"
 	"encoding/hex"
 	"fmt"
 	"reflect"
-
-	"github.com/minio/sio"
 )
 
+// Encrypt encrypts data using the bytes of key as master key.
+// Use EncryptWithPassphrase when key is a human passphrase.
 func Encrypt(key string, cryptoParams CryptoParams, data EncryptTransformer) (any, error) {
 	return NewEncrypter(hex.EncodeToString([]byte(key)), cryptoParams, data.GetTransformConfig()).Transform(data)
 }
 
-func NewEncrypter(masterKeyHex string, p CryptoParams, c TransformConfig) Encrypter {
+// EncryptWithPassphrase encrypts data using a master key derived from passphrase using Argon2id with DefaultArgon2idParams.
+func EncryptWithPassphrase(passphrase string, cryptoParams CryptoParams, data EncryptTransformer) (any, error) {
+	var (
+		err  error
+		keys KeySource
+	)
+	if keys, err = NewPassphrase(passphrase, DefaultArgon2idParams()); err != nil {
+		return nil, err
+	}
+	return NewEncrypterWithKeySource(keys, cryptoParams, data.GetTransformConfig()).Transform(data)
+}
+
+func NewEncrypter(masterKeyHex string, p CryptoParams, c TransformConfig, opts ...Option) Encrypter {
+	return NewEncrypterWithKeySource(masterKey(masterKeyHex), p, c, opts...)
+}
+
+// NewEncrypterWithKeySource returns an Encrypter which gets the master key from the KeySource, e.g. a Keyring.
+func NewEncrypterWithKeySource(k KeySource, p CryptoParams, c TransformConfig, opts ...Option) Encrypter {
 	return Encrypter{
-		key:    masterKeyHex,
-		params: p,
-		config: c,
+		keys:    k,
+		params:  p,
+		config:  c,
+		options: newOptions(opts),
 	}
 }
 
 type Encrypter struct {
-	key    string
-	params CryptoParams
-	config TransformConfig
+	keys    KeySource
+	params  CryptoParams
+	config  TransformConfig
+	options options
 }
 
+// Transform encrypts r, which can be a struct or a pointer to a struct.
+// A pointer is returned when the encrypted type in the TransformConfig is a pointer.
 func (t Encrypter) Transform(r any) (any, error) {
 	var (
-		err    error
-		tags   map[string]Tag
-		output any
+		err        error
+		plan       *structPlan
+		inputValue reflect.Value
+		output     reflect.Value
 	)
 
-	// Get input type and value of r
-	inputType := reflect.TypeOf(r)
-	inputValue := reflect.ValueOf(r)
+	if inputValue, err = structValue(r); err != nil {
+		return nil, err
+	}
+	outputType, asPointer := structType(reflect.TypeOf(t.config.Encrypted))
+	if outputType == nil {
+		return nil, fmt.Errorf("transform config does not declare the encrypted type")
+	}
+
+	// Get the plan to convert the type of r into the encrypted type
+	if plan, err = getPlan(inputValue.Type(), outputType, encryptMode); err != nil {
+		return nil, err
+	}
 
-	// Get the struct tags for r
-	tags, err = getTags(r)
-	if err != nil {
+	if output, err = t.transform(plan, inputValue, ""); err != nil {
 		return nil, err
 	}
+	if asPointer {
+		return output.Addr().Interface(), nil
+	}
+	return output.Interface(), nil
+}
 
-	// Get struct to which data must be converted
-	output = t.config.Encrypted
+// transform encrypts the struct inputValue, path is the location of the struct within the top-level struct
+func (t Encrypter) transform(plan *structPlan, inputValue reflect.Value, path string) (reflect.Value, error) {
+	var (
+		err error
+		key []byte
+		c   *cryptoContext
+	)
 
-	// To be able to set the fields for the output, we need to get the Value of the output pointer
-	outputValue := reflect.ValueOf(&output).Elem()
-	// To be able to mutate the outputValue, we must first get a temporary outputValue
-	tmp := reflect.New(outputValue.Elem().Type()).Elem()
-	tmp.Set(outputValue.Elem())
+	if t.options.strict {
+		if err = plan.checkStrict(); err != nil {
+			return reflect.Value{}, newFieldError(path, err)
+		}
+	}
+
+	// Get the master key, the KeySource records which key is used in the CryptoParams
+	params := t.params
+	params.Version = cryptoParamsVersion
+	params.MAC = ""
+	params.KeyCheck = ""
+	if t.options.encoding != "" {
+		params.Encoding = t.options.encoding
+	}
+	if key, err = t.keys.encryptionKey(&params); err != nil {
+		return reflect.Value{}, newFieldError(path, fmt.Errorf("could not get encryption key: %w", err))
+	}
+
+	if c, err = newCryptoContext(params, key, plan.output); err != nil {
+		return reflect.Value{}, newFieldError(path, err)
+	}
+	params.KeyCheck = c.keyCheck()
+
+	output := reflect.New(plan.output).Elem()
 
 	// Store the encryption parameters for the output
-	tmp.FieldByName("CryptoParams").Set(reflect.ValueOf(t.params))
+	output.Field(plan.cryptoParamsIndex).Set(reflect.ValueOf(params))
 
-	// Process all fields in r
-	for i := 0; i < inputValue.NumField(); i++ {
-		fieldName := inputType.Field(i).Name
-		fieldType := inputType.Field(i).Type
-		fieldValue := inputValue.Field(i)
+	// Process all fields in the input
+	for _, field := range plan.fields {
+		fieldValue := inputValue.FieldByIndex(field.inputIndex)
 
 		// If the field must not be encrypted, store the input value into the output value
-		// Input and Output field type MUST be the same!!!
-		if !tags[fieldName].Enabled {
-			tmp.FieldByName(fieldName).Set(fieldValue)
+		if field.value == nil {
+			output.FieldByIndex(field.outputIndex).Set(fieldValue)
+			continue
+		}
+		// The zero value of a field with omitempty is not encrypted, the output keeps its zero value
+		if field.tag.OmitEmpty && fieldValue.IsZero() {
 			continue
 		}
 
-		var encryptedValue reflect.Value
-		switch inputValue.Field(i).Kind() {
-		case reflect.Slice:
-			if encryptedValue, err = t.encryptSlice(fieldValue, tmp.FieldByName(fieldName).Type()); err != nil {
-				return nil, err
-			}
-		default:
-			if encryptedValue, err = t.encryptFields(fieldType, fieldValue); err != nil {
-				return nil, err
-			}
+		var (
+			encryptedValue reflect.Value
+			fieldContext   *cryptoContext
+		)
+		if fieldContext, err = c.field(field.tag, field.name); err != nil {
+			return reflect.Value{}, newFieldError(fieldPath(path, field.name), err)
+		}
+		if encryptedValue, err = t.encryptValue(field.value, fieldValue, fieldContext, fieldPath(path, field.name)); err != nil {
+			return reflect.Value{}, err
 		}
-		tmp.FieldByName(fieldName).Set(encryptedValue)
+		output.FieldByIndex(field.outputIndex).Set(encryptedValue)
 	}
 
-	outputValue.Set(tmp)
+	// Store the MAC over all fields of the output
+	if t.options.integrity {
+		var mac []byte
+		if mac, err = c.integrityMAC(output, plan.cryptoParamsIndex); err != nil {
+			return reflect.Value{}, newFieldError(path, err)
+		}
+		params.MAC = hex.EncodeToString(mac)
+		output.Field(plan.cryptoParamsIndex).Set(reflect.ValueOf(params))
+	}
 	return output, nil
 }
 
-func (t Encrypter) encryptSlice(inputValue reflect.Value, outputType reflect.Type) (reflect.Value, error) {
+func (t Encrypter) encryptValue(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	switch plan.op {
+	case opSlice:
+		return t.encryptSlice(plan, inputValue, c, path)
+	case opBytes:
+		return t.encryptBytes(plan, inputValue, c, path)
+	case opMarshaler:
+		return t.encryptMarshaler(plan, inputValue, c, path)
+	case opInterface:
+		return t.encryptInterface(plan, inputValue, c, path)
+	case opArray:
+		return t.encryptArray(plan, inputValue, c, path)
+	case opMap:
+		return t.encryptMap(plan, inputValue, c, path)
+	case opPointer:
+		return t.encryptPointer(plan, inputValue, c, path)
+	case opTransformer:
+		return t.encryptStruct(plan, inputValue, path)
+	default:
+		return t.encryptFields(plan, inputValue, c, path)
+	}
+}
+
+func (t Encrypter) encryptSlice(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
 	var (
 		err    error
 		output reflect.Value
 	)
 
-	// Create a slice of the outputType with the correct capacity
-	output = reflect.MakeSlice(outputType, 0, 0)
+	// Create a slice of the output type with the correct capacity
+	output = reflect.MakeSlice(plan.output, 0, inputValue.Len())
 
 	// Loop over the input slice and encrypt each element
 	for i := 0; i < inputValue.Len(); i++ {
 		var encryptedValue reflect.Value
-		if encryptedValue, err = t.encryptFields(reflect.TypeOf(inputValue.Index(i).Interface()), inputValue.Index(i)); err != nil {
+		if encryptedValue, err = t.encryptValue(plan.elem, inputValue.Index(i), c, indexPath(path, i)); err != nil {
 			return reflect.Value{}, err
 		}
 		// Append the encrypted value to the output
@@ -124,63 +212,134 @@ func (t Encrypter) encryptSlice(inputValue reflect.Value, outputType reflect.Typ
 	return output, nil
 }
 
-func (t Encrypter) encryptFields(fieldType reflect.Type, fieldValue reflect.Value) (reflect.Value, error) {
-	var (
-		err          error
-		cryptoConfig sio.Config
-		out          reflect.Value
-	)
-	// Generate sio.Config from CryptoParams
-	cryptoConfig, err = t.params.GetCryptoConfig(t.key)
-	if err != nil {
-		return reflect.Value{}, fmt.Errorf("could not initialize crypto parameters: %w", err)
+func (t Encrypter) encryptArray(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// The length of both array types is validated when the plan is compiled
+	output := reflect.New(plan.output).Elem()
+	for i := 0; i < inputValue.Len(); i++ {
+		encryptedValue, err := t.encryptValue(plan.elem, inputValue.Index(i), c, indexPath(path, i))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		output.Index(i).Set(encryptedValue)
 	}
+	return output, nil
+}
 
-	// Check if the current field is a struct, which implements the interface EncryptTransformer
-	// Decide to encrypt the field or the embedded struct
-	if fieldType.Implements(reflect.TypeOf((*EncryptTransformer)(nil)).Elem()) {
-		if out, err = t.encryptStruct(fieldValue, t.params.CipherSuite); err != nil {
-			return reflect.Value{}, err
+func (t Encrypter) encryptMap(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	var err error
+
+	// Keep nil maps nil
+	if inputValue.IsNil() {
+		return reflect.Zero(plan.output), nil
+	}
+
+	output := reflect.MakeMapWithSize(plan.output, inputValue.Len())
+	iter := inputValue.MapRange()
+	for iter.Next() {
+		var encryptedKey, encryptedValue reflect.Value
+
+		// Encrypted keys are bound to the map, the values are bound to their plaintext key
+		encryptedKey = iter.Key()
+		if plan.key != nil {
+			if encryptedKey, err = t.encryptValue(plan.key, iter.Key(), c, path); err != nil {
+				return reflect.Value{}, err
+			}
 		}
-	} else {
-		// Convert fieldValue to hex encoded string
-		var source string
-		source, err = convertValueToHexString(fieldValue)
-		if err != nil {
+		if encryptedValue, err = t.encryptValue(plan.elem, iter.Value(), c, mapKeyPath(path, iter.Key())); err != nil {
 			return reflect.Value{}, err
 		}
-		sourceDataReader := bytes.NewBuffer([]byte(source))
-		encryptedDataWriter := bytes.NewBuffer(make([]byte, 0))
+		output.SetMapIndex(encryptedKey, encryptedValue)
+	}
+	return output, nil
+}
 
-		// Encrypt data from sourceDataReader into EncryptedDataWrite using cryptoConfig
-		if _, err = sio.Encrypt(encryptedDataWriter, sourceDataReader, cryptoConfig); err != nil {
-			return reflect.Value{}, fmt.Errorf("failed to encrypt data: %w", err)
-		}
+func (t Encrypter) encryptPointer(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// Keep nil pointers nil, instead of encrypting the zero value
+	if inputValue.IsNil() {
+		return reflect.Zero(plan.output), nil
+	}
+
+	value, err := t.encryptValue(plan.elem, inputValue.Elem(), c, path)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	output := reflect.New(plan.output.Elem())
+	output.Elem().Set(value)
+	return output, nil
+}
 
-		// Encode the encrypted bytes to a hex encoded string
-		out = reflect.ValueOf(hex.EncodeToString(encryptedDataWriter.Bytes()))
+func (t Encrypter) encryptBytes(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// Keep nil byte slices nil, a ciphertext is never empty
+	if inputValue.IsNil() {
+		return reflect.Zero(plan.output), nil
 	}
-	return out, nil
+	return t.encryptBlob(plan, inputValue.Bytes(), c, path)
 }
 
-func (t Encrypter) encryptStruct(field reflect.Value, cipherSuite string) (reflect.Value, error) {
+func (t Encrypter) encryptMarshaler(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	data, err := marshalValue(inputValue, plan.text)
+	if err != nil {
+		return reflect.Value{}, &FieldError{Path: path, Err: fmt.Errorf("could not marshal %s: %w", inputValue.Type(), err)}
+	}
+	return t.encryptBlob(plan, data, c, path)
+}
+
+func (t Encrypter) encryptInterface(plan *valuePlan, inputValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// Keep nil interfaces nil
+	if inputValue.IsNil() {
+		return reflect.Zero(plan.output), nil
+	}
+
+	data, err := marshalRegistered(inputValue.Elem())
+	if err != nil {
+		return reflect.Value{}, &FieldError{Path: path, Err: err}
+	}
+	return t.encryptBlob(plan, data, c, path)
+}
+
+// encryptBlob encrypts data as a single value
+func (t Encrypter) encryptBlob(plan *valuePlan, data []byte, c *cryptoContext, path string) (reflect.Value, error) {
+	encrypted, err := c.seal(data, path)
+	if err != nil {
+		return reflect.Value{}, &FieldError{Path: path, Err: err}
+	}
+
+	// Store the encrypted bytes as-is for byte slices, or encoded for strings
+	if plan.output.Kind() == reflect.String {
+		return reflect.ValueOf(c.encode(encrypted)).Convert(plan.output), nil
+	}
+	return reflect.ValueOf(encrypted).Convert(plan.output), nil
+}
+
+func (t Encrypter) encryptFields(plan *valuePlan, fieldValue reflect.Value, c *cryptoContext, path string) (reflect.Value, error) {
+	// Serialize fieldValue to raw bytes, encrypted bound to the path of the field
+	source, err := convertValueToBytes(fieldValue)
+	if err != nil {
+		return reflect.Value{}, &FieldError{Path: path, Err: err}
+	}
+	return t.encryptBlob(plan, source, c, path)
+}
+
+func (t Encrypter) encryptStruct(plan *valuePlan, field reflect.Value, path string) (reflect.Value, error) {
 	var (
 		err          error
-		encrypter    Encrypter
 		cryptoParams CryptoParams
-		output       any
+		nestedPlan   *structPlan
 	)
 
-	cryptoParams, err = NewCryptoParams(cipherSuite)
-	if err != nil {
+	if nestedPlan, err = getPlan(plan.input, plan.output, encryptMode); err != nil {
 		return reflect.Value{}, err
 	}
-	encrypter = NewEncrypter(t.key, cryptoParams, getEmbeddedTransformConfig(field))
 
-	if output, err = encrypter.Transform(field.Interface()); err != nil {
+	// Every nested struct is encrypted using its own CryptoParams
+	cryptoParams, err = t.params.renew()
+	if err != nil {
 		return reflect.Value{}, err
 	}
-
-	return reflect.ValueOf(output), nil
-
+	encrypter := Encrypter{
+		keys:    t.keys,
+		params:  cryptoParams,
+		options: t.options,
+	}
+	return encrypter.transform(nestedPlan, field, path)
 }
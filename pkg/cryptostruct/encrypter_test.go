@@ -16,4 +16,573 @@
 
 package cryptostruct
 
-// TODO Add tests
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testReceiverData declares its methods on pointer receivers, and its TransformConfig uses pointers
+type testReceiverData struct {
+	Name    string              `secure:"true"`
+	Nested  testReceiverNested  `secure:"true"`
+	Pointer *testReceiverNested `secure:"true"`
+}
+
+func (d *testReceiverData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: &testReceiverData{},
+		Encrypted: &testSecureReceiverData{},
+	}
+}
+
+type testSecureReceiverData struct {
+	Name         string                    `secure:"true"`
+	Nested       testSecureReceiverNested  `secure:"true"`
+	Pointer      *testSecureReceiverNested `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d *testSecureReceiverData) GetTransformConfig() TransformConfig {
+	return (&testReceiverData{}).GetTransformConfig()
+}
+
+func (d *testSecureReceiverData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testReceiverNested struct {
+	Value int `secure:"true"`
+}
+
+func (d *testReceiverNested) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testReceiverNested{},
+		Encrypted: testSecureReceiverNested{},
+	}
+}
+
+type testSecureReceiverNested struct {
+	Value        string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d *testSecureReceiverNested) GetTransformConfig() TransformConfig {
+	return (&testReceiverNested{}).GetTransformConfig()
+}
+
+func (d *testSecureReceiverNested) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func TestEncrypter_Transform(t *testing.T) {
+	input := newTestData()
+	params := newTestCryptoParams(t)
+
+	output, err := NewEncrypter(testMasterKey, params, input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	encrypted, ok := output.(testSecureData)
+	if !ok {
+		t.Fatalf("Transform() returned %T, want testSecureData", output)
+	}
+	if encrypted.CryptoParams.KeyCheck == "" {
+		t.Error("KeyCheck is not set")
+	}
+	params.KeyCheck = encrypted.CryptoParams.KeyCheck
+	if encrypted.CryptoParams != params {
+		t.Errorf("CryptoParams = %+v, want %+v", encrypted.CryptoParams, params)
+	}
+	if encrypted.Name == "" || encrypted.Name == input.Name {
+		t.Errorf("Name was not encrypted: %q", encrypted.Name)
+	}
+	if encrypted.Plain != input.Plain {
+		t.Errorf("Plain = %q, want %q", encrypted.Plain, input.Plain)
+	}
+	if len(encrypted.NumberSlice) != len(input.NumberSlice) || len(encrypted.SliceDetails) != len(input.SliceDetails) {
+		t.Errorf("slice lengths do not match the input")
+	}
+
+	// Nested structs are encrypted using their own nonce
+	nonces := map[string]bool{encrypted.CryptoParams.Nonce: true}
+	for _, p := range []CryptoParams{encrypted.Details.CryptoParams, encrypted.SliceDetails[0].CryptoParams, encrypted.SliceDetails[1].CryptoParams} {
+		if p.CipherSuite != params.CipherSuite {
+			t.Errorf("nested CipherSuite = %s, want %s", p.CipherSuite, params.CipherSuite)
+		}
+		if nonces[p.Nonce] {
+			t.Errorf("nonce %s is reused", p.Nonce)
+		}
+		nonces[p.Nonce] = true
+	}
+}
+
+func TestEncrypter_Transform_Pointer(t *testing.T) {
+	input := &testReceiverData{
+		Name:    "insecuredata",
+		Nested:  testReceiverNested{Value: 1},
+		Pointer: &testReceiverNested{Value: 2},
+	}
+
+	// The TransformConfig declares pointers, so pointers are returned
+	encrypted, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	secure, ok := encrypted.(*testSecureReceiverData)
+	if !ok {
+		t.Fatalf("Encrypter.Transform() returned %T, want *testSecureReceiverData", encrypted)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(secure)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+
+	// Pointers are accepted when the TransformConfig declares values
+	data := newTestData()
+	encrypted, err = NewEncrypter(testMasterKey, newTestCryptoParams(t), data.GetTransformConfig()).Transform(&data)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	if _, ok = encrypted.(testSecureData); !ok {
+		t.Errorf("Encrypter.Transform() returned %T, want testSecureData", encrypted)
+	}
+}
+
+func TestEncrypter_Transform_NilPointer(t *testing.T) {
+	var input *testReceiverData
+	if _, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input); err == nil {
+		t.Error("Transform() expected error for nil pointer")
+	}
+}
+
+type testLargeSliceData struct {
+	Numbers []int              `secure:"true"`
+	Details []testEmbeddedData `secure:"true"`
+}
+
+func (d testLargeSliceData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testLargeSliceData{},
+		Encrypted: testSecureLargeSliceData{},
+	}
+}
+
+type testSecureLargeSliceData struct {
+	Numbers      []string                 `secure:"true"`
+	Details      []testSecureEmbeddedData `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureLargeSliceData) GetTransformConfig() TransformConfig {
+	return testLargeSliceData{}.GetTransformConfig()
+}
+
+func (d testSecureLargeSliceData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+// referenceEncrypter encrypts the way Encrypter did before the key was derived once per struct: the master key is
+// decoded and the key of the struct is derived again for every slice element. It only supports slices of scalars,
+// and serves as the baseline for BenchmarkEncrypterTransform_LargeSlice.
+type referenceEncrypter struct {
+	Encrypter
+}
+
+func (t referenceEncrypter) encryptSlice(inputValue reflect.Value, outputType reflect.Type, secureType reflect.Type, path string) (reflect.Value, error) {
+	plan := &valuePlan{op: opScalar, input: inputValue.Type().Elem(), output: outputType.Elem()}
+	output := reflect.MakeSlice(outputType, 0, inputValue.Len())
+	for i := 0; i < inputValue.Len(); i++ {
+		params := t.params
+		params.Version = cryptoParamsVersion
+		key, err := t.keys.encryptionKey(&params)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		c, err := newCryptoContext(params, key, secureType)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		encryptedValue, err := t.encryptFields(plan, inputValue.Index(i), c, indexPath(path, i))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		output = reflect.Append(output, encryptedValue)
+	}
+	return output, nil
+}
+
+func BenchmarkEncrypterTransform_LargeSlice(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		input := testLargeSliceData{
+			Numbers: make([]int, size),
+		}
+		for i := range input.Numbers {
+			input.Numbers[i] = i
+		}
+		encrypter := NewEncrypter(testMasterKey, newTestCryptoParams(b), input.GetTransformConfig())
+		reference := referenceEncrypter{encrypter}
+
+		b.Run(strconv.Itoa(size)+"/once", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := encrypter.Transform(input); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(strconv.Itoa(size)+"/per-element", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := reference.encryptSlice(reflect.ValueOf(input.Numbers), reflect.TypeOf([]string{}), reflect.TypeOf(testSecureLargeSliceData{}), "Numbers"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type testTagOptionsData struct {
+	Email   string            `secure:"true,key=pii,encoding=base64,deterministic"`
+	Phone   string            `secure:"true,key=pii"`
+	Emails  []string          `secure:"true,deterministic"`
+	Token   []int             `secure:"true,encoding=base64url"`
+	Note    string            `secure:"true,omitempty"`
+	Details *testEmbeddedData `secure:"true,omitempty"`
+}
+
+func (d testTagOptionsData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testTagOptionsData{},
+		Encrypted: testSecureTagOptionsData{},
+	}
+}
+
+type testSecureTagOptionsData struct {
+	Email        string                  `secure:"true,key=pii,encoding=base64,deterministic"`
+	Phone        string                  `secure:"true,key=pii"`
+	Emails       []string                `secure:"true,deterministic"`
+	Token        []string                `secure:"true,encoding=base64url"`
+	Note         string                  `secure:"true,omitempty"`
+	Details      *testSecureEmbeddedData `secure:"true,omitempty"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureTagOptionsData) GetTransformConfig() TransformConfig {
+	return testTagOptionsData{}.GetTransformConfig()
+}
+
+func (d testSecureTagOptionsData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func TestEncrypter_Transform_TagOptions(t *testing.T) {
+	input := testTagOptionsData{
+		Email:  "user@example.com",
+		Phone:  "+3200000000",
+		Emails: []string{"a@example.com", "b@example.com", "a@example.com"},
+		Token:  []int{1, 2},
+	}
+	params := newTestCryptoParams(t)
+
+	first, err := NewEncrypter(testMasterKey, params, input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	second, err := NewEncrypter(testMasterKey, params, input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	encrypted := first.(testSecureTagOptionsData)
+
+	// Deterministic fields produce the same ciphertext for the same value, other fields do not
+	if encrypted.Email != second.(testSecureTagOptionsData).Email {
+		t.Error("deterministic field Email has different ciphertexts")
+	}
+	if encrypted.Phone == second.(testSecureTagOptionsData).Phone {
+		t.Error("field Phone has equal ciphertexts")
+	}
+	// The ciphertext is bound to its field, not to its index, so equal elements have equal ciphertexts
+	if encrypted.Emails[0] != encrypted.Emails[2] {
+		t.Error("deterministic elements with equal values have different ciphertexts")
+	}
+	if !reflect.DeepEqual(encrypted.Emails, second.(testSecureTagOptionsData).Emails) {
+		t.Error("deterministic field Emails has different ciphertexts")
+	}
+
+	if _, err = base64.StdEncoding.DecodeString(encrypted.Email); err != nil {
+		t.Errorf("Email is not base64 encoded: %v", err)
+	}
+	if _, err = base64.URLEncoding.DecodeString(encrypted.Token[0]); err != nil {
+		t.Errorf("Token is not base64url encoded: %v", err)
+	}
+	if encrypted.Note != "" || encrypted.Details != nil {
+		t.Errorf("omitempty fields are not empty: %q, %v", encrypted.Note, encrypted.Details)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+}
+
+type testDeterministicData struct {
+	Email string `secure:"true,deterministic"`
+	Alias string `secure:"true,deterministic"`
+}
+
+func (d testDeterministicData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testDeterministicData{},
+		Encrypted: testSecureDeterministicData{},
+	}
+}
+
+type testSecureDeterministicData struct {
+	Email        string `secure:"true,deterministic"`
+	Alias        string `secure:"true,deterministic"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureDeterministicData) GetTransformConfig() TransformConfig {
+	return testDeterministicData{}.GetTransformConfig()
+}
+
+func (d testSecureDeterministicData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testDeterministicOwnerData struct {
+	Owners []testDeterministicData `secure:"true"`
+}
+
+func (d testDeterministicOwnerData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testDeterministicOwnerData{},
+		Encrypted: testSecureDeterministicOwnerData{},
+	}
+}
+
+type testSecureDeterministicOwnerData struct {
+	Owners       []testSecureDeterministicData `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureDeterministicOwnerData) GetTransformConfig() TransformConfig {
+	return testDeterministicOwnerData{}.GetTransformConfig()
+}
+
+func (d testSecureDeterministicOwnerData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func TestEncrypter_Transform_Deterministic(t *testing.T) {
+	value := testDeterministicData{Email: "user@example.com", Alias: "user@example.com"}
+
+	// Records encrypted using different CryptoParams have equal ciphertexts for equal values
+	first, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), value.GetTransformConfig()).Transform(value)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	second, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), value.GetTransformConfig()).Transform(value)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	encrypted := first.(testSecureDeterministicData)
+	if encrypted.Email != second.(testSecureDeterministicData).Email {
+		t.Error("deterministic field Email has different ciphertexts in different records")
+	}
+	// Ciphertexts are bound to their field, so equal values in other fields differ
+	if encrypted.Email == encrypted.Alias {
+		t.Error("deterministic fields Email and Alias have equal ciphertexts")
+	}
+
+	// Nested structs are encrypted using their own CryptoParams, but still have equal ciphertexts
+	owners := testDeterministicOwnerData{Owners: []testDeterministicData{value, value}}
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), owners.GetTransformConfig()).Transform(owners)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	nested := output.(testSecureDeterministicOwnerData)
+	if nested.Owners[0].Email != encrypted.Email || nested.Owners[1].Email != encrypted.Email {
+		t.Error("deterministic field Email has different ciphertexts in nested structs")
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, owners.GetTransformConfig()).Transform(nested)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, owners) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, owners)
+	}
+
+	// Swapping deterministic ciphertexts between fields is detected
+	encrypted.Email, encrypted.Alias = encrypted.Alias, encrypted.Email
+	if _, err = NewDecrypter(testMasterKey, value.GetTransformConfig()).Transform(encrypted); !errors.Is(err, ErrTampered) {
+		t.Errorf("Decrypter.Transform() error = %v, want %v", err, ErrTampered)
+	}
+}
+
+type testUntaggedData struct {
+	Name     string `secure:"true"`
+	Password string
+	Comment  string
+}
+
+type testSecureUntaggedData struct {
+	Name         string `secure:"true"`
+	Password     string
+	Comment      string
+	CryptoParams CryptoParams
+}
+
+func (d testSecureUntaggedData) GetTransformConfig() TransformConfig {
+	return TransformConfig{Decrypted: testUntaggedData{}, Encrypted: testSecureUntaggedData{}}
+}
+
+func (d testSecureUntaggedData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func TestEncrypter_Transform_Strict(t *testing.T) {
+	config := TransformConfig{Decrypted: testUntaggedData{}, Encrypted: testSecureUntaggedData{}}
+	input := testUntaggedData{Name: "name", Password: "password"}
+
+	_, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), config, WithStrict()).Transform(input)
+	if !errors.Is(err, ErrUntaggedField) {
+		t.Fatalf("Transform() error = %v, want %v", err, ErrUntaggedField)
+	}
+	if !strings.HasSuffix(err.Error(), "Password, Comment") {
+		t.Errorf("Transform() error = %v, want all untagged fields", err)
+	}
+
+	// Without strict mode, untagged fields are copied as-is
+	encrypted, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), config).Transform(input)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if _, err = NewDecrypter(testMasterKey, config, WithStrict()).Transform(encrypted.(testSecureUntaggedData)); !errors.Is(err, ErrUntaggedField) {
+		t.Errorf("Decrypter.Transform() error = %v, want %v", err, ErrUntaggedField)
+	}
+
+	// Strict mode applies to nested structs, of which all fields are tagged
+	if _, err = NewEncrypter(testMasterKey, newTestCryptoParams(t), testData{}.GetTransformConfig(), WithStrict()).Transform(newTestData()); err != nil {
+		t.Errorf("Transform() error = %v", err)
+	}
+}
+
+type testRawData struct {
+	Name  string           `secure:"true"`
+	Count int              `secure:"true"`
+	Tags  []string         `secure:"true"`
+	Extra testEmbeddedData `secure:"true"`
+}
+
+func (d testRawData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testRawData{},
+		Encrypted: testSecureRawData{},
+	}
+}
+
+// testSecureRawData stores the ciphertexts of scalar values as raw bytes
+type testSecureRawData struct {
+	Name         []byte                 `secure:"true"`
+	Count        []byte                 `secure:"true"`
+	Tags         [][]byte               `secure:"true"`
+	Extra        testSecureEmbeddedData `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureRawData) GetTransformConfig() TransformConfig {
+	return testRawData{}.GetTransformConfig()
+}
+
+func (d testSecureRawData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func TestEncrypter_Transform_Encoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		decode   func(string) ([]byte, error)
+	}{
+		{encoding: EncodingHex, decode: hex.DecodeString},
+		{encoding: EncodingBase64, decode: base64.StdEncoding.DecodeString},
+		{encoding: EncodingBase64URL, decode: base64.URLEncoding.DecodeString},
+	}
+
+	input := newTestData()
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig(), WithEncoding(tt.encoding)).Transform(input)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			encrypted := output.(testSecureData)
+
+			// The encoding is recorded in the CryptoParams of every struct
+			if encrypted.CryptoParams.Encoding != tt.encoding || encrypted.Details.CryptoParams.Encoding != tt.encoding {
+				t.Errorf("Encoding = %q and %q, want %q", encrypted.CryptoParams.Encoding, encrypted.Details.CryptoParams.Encoding, tt.encoding)
+			}
+			if _, err = tt.decode(encrypted.Name); err != nil {
+				t.Errorf("Name is not %s encoded: %v", tt.encoding, err)
+			}
+			if _, err = tt.decode(encrypted.Details.FirstName); err != nil {
+				t.Errorf("Details.FirstName is not %s encoded: %v", tt.encoding, err)
+			}
+
+			// A Decrypter uses the encoding recorded in the CryptoParams
+			decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+			if err != nil {
+				t.Fatalf("Decrypter.Transform() error = %v", err)
+			}
+			if !reflect.DeepEqual(decrypted, input) {
+				t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+			}
+		})
+	}
+
+	if _, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig(), WithEncoding("base32")).Transform(input); err == nil {
+		t.Error("Transform() expected error for unknown encoding")
+	}
+}
+
+func TestEncrypter_Transform_RawBytes(t *testing.T) {
+	input := testRawData{
+		Name:  "insecuredata",
+		Count: 42,
+		Tags:  []string{"a", "b"},
+		Extra: testEmbeddedData{FirstName: "First"},
+	}
+
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	encrypted := output.(testSecureRawData)
+
+	// The raw plaintext is encrypted as-is, adding only the binding, the header and the authentication tag
+	overhead := len(encrypted.Name) - len(input.Name)
+	if tagOverhead := len(encrypted.Tags[0]) - len(input.Tags[0]); overhead != tagOverhead {
+		t.Errorf("ciphertext overhead = %d, want %d", overhead, tagOverhead)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrAuthentication is returned when a ciphertext cannot be decrypted, because it was modified or corrupted.
+	// It is also returned for a wrong key when the CryptoParams of the struct have no KeyCheck.
+	ErrAuthentication = errors.New("message authentication failed")
+	// ErrWrongKey is returned when the key of a struct does not match the KeyCheck in its CryptoParams,
+	// e.g. because the wrong master key or passphrase is used.
+	ErrWrongKey = errors.New("wrong key")
+	// ErrTampered is returned when a ciphertext was moved to another field, slice index or map key of the same struct,
+	// or when the CryptoParams of the struct were modified. Every struct, including nested structs, has its own key,
+	// so a ciphertext moved to another struct cannot be decrypted and is reported as ErrAuthentication.
+	ErrTampered = errors.New("ciphertext does not belong to this field")
+	// ErrIntegrity is returned when the integrity MAC of a struct is missing or does not match its fields.
+	ErrIntegrity = errors.New("integrity check failed")
+	// ErrUnsupportedKind is returned for values which cannot be encrypted, like functions and channels.
+	ErrUnsupportedKind = errors.New("unsupported kind")
+	// ErrInvalidCipherSuite is returned when CryptoParams contain an unknown cipher suite.
+	ErrInvalidCipherSuite = errors.New("invalid cipher suite")
+	// ErrMissingNonce is returned when CryptoParams do not contain a nonce.
+	ErrMissingNonce = errors.New("nonce is not set")
+	// ErrKeyNotFound is returned when the key recorded in CryptoParams is not available in the Keyring or KeyProvider.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrUntaggedField is returned in strict mode for structs with fields which do not declare a secure tag.
+	ErrUntaggedField = errors.New("fields without secure tag")
+)
+
+// FieldError is returned when a single field, slice element, map value or nested struct cannot be transformed.
+type FieldError struct {
+	// Path of the field in the top-level struct, e.g. SliceDetails[1].Details.FirstName
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s: %v", e.Path, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// newFieldError wraps err in a FieldError for path, errors of the top-level struct itself are returned as-is
+func newFieldError(path string, err error) error {
+	if path == "" {
+		return err
+	}
+	return &FieldError{Path: path, Err: err}
+}
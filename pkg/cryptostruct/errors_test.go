@@ -0,0 +1,147 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+type testUnsupportedData struct {
+	Callback func() `secure:"true"`
+}
+
+type testSecureUnsupportedData struct {
+	Callback     string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func TestFieldError(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *testSecureData)
+		path   string
+		target error
+	}{
+		{
+			name: "corrupted ciphertext",
+			modify: func(d *testSecureData) {
+				d.SliceDetails[1].FirstName = d.SliceDetails[1].FirstName[:len(d.SliceDetails[1].FirstName)-2] + "00"
+			},
+			path:   "SliceDetails[1].FirstName",
+			target: ErrAuthentication,
+		},
+		{
+			name: "moved ciphertext",
+			modify: func(d *testSecureData) {
+				d.NumberSlice[0], d.NumberSlice[2] = d.NumberSlice[2], d.NumberSlice[0]
+			},
+			path:   "NumberSlice[0]",
+			target: ErrTampered,
+		},
+		{
+			name: "invalid cipher suite in nested struct",
+			modify: func(d *testSecureData) {
+				d.Details.CryptoParams.CipherSuite = "AES_128_CBC"
+			},
+			path:   "Details",
+			target: ErrInvalidCipherSuite,
+		},
+		{
+			name: "missing nonce in nested struct",
+			modify: func(d *testSecureData) {
+				d.SliceDetails[0].CryptoParams.Nonce = ""
+			},
+			path:   "SliceDetails[0]",
+			target: ErrMissingNonce,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := newTestEncryptedData(t)
+			tt.modify(&encrypted)
+
+			_, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(encrypted)
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatalf("Transform() error = %v, want FieldError", err)
+			}
+			if fieldErr.Path != tt.path {
+				t.Errorf("Path = %s, want %s", fieldErr.Path, tt.path)
+			}
+			if !errors.Is(err, tt.target) {
+				t.Errorf("Transform() error = %v, want %v", err, tt.target)
+			}
+		})
+	}
+}
+
+func TestErrors_WrongKey(t *testing.T) {
+	encrypted := newTestEncryptedData(t)
+
+	wrongKey := hex.EncodeToString([]byte("wrongKey"))
+	_, err := NewDecrypter(wrongKey, testData{}.GetTransformConfig()).Transform(encrypted)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrWrongKey)
+	}
+	if errors.Is(err, ErrTampered) || errors.Is(err, ErrAuthentication) {
+		t.Errorf("Transform() error = %v, a wrong key must not be reported as tampering or corruption", err)
+	}
+
+	// The correct key with corrupted data is reported differently
+	corrupted := newTestEncryptedData(t)
+	corrupted.Name = corrupted.Name[:len(corrupted.Name)-2] + "00"
+	_, err = NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(corrupted)
+	if !errors.Is(err, ErrAuthentication) || errors.Is(err, ErrWrongKey) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrAuthentication)
+	}
+
+	// Without a key check, a wrong key cannot be told apart from corrupted data
+	encrypted.CryptoParams.KeyCheck = ""
+	if _, err = NewDecrypter(wrongKey, testData{}.GetTransformConfig()).Transform(encrypted); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrAuthentication)
+	}
+
+	keyring := NewKeyring()
+	if err = keyring.AddKey("other", testMasterKey); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+	if _, err = NewDecrypterWithKeySource(keyring, testData{}.GetTransformConfig()).Transform(encrypted); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestErrors_CryptoParams(t *testing.T) {
+	if _, err := NewCryptoParams("AES_128_CBC"); !errors.Is(err, ErrInvalidCipherSuite) {
+		t.Errorf("NewCryptoParams() error = %v, want %v", err, ErrInvalidCipherSuite)
+	}
+
+	params := newTestCryptoParams(t)
+	params.Nonce = ""
+	if _, err := NewEncrypter(testMasterKey, params, testData{}.GetTransformConfig()).Transform(newTestData()); !errors.Is(err, ErrMissingNonce) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrMissingNonce)
+	}
+}
+
+func TestErrors_UnsupportedKind(t *testing.T) {
+	config := TransformConfig{Decrypted: testUnsupportedData{}, Encrypted: testSecureUnsupportedData{}}
+	if err := ValidateTransformConfig(config); !errors.Is(err, ErrUnsupportedKind) {
+		t.Errorf("ValidateTransformConfig() error = %v, want %v", err, ErrUnsupportedKind)
+	}
+}
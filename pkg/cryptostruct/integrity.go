@@ -0,0 +1,205 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// integrityMAC computes the MAC over all fields of the secure struct v, except its CryptoParams field.
+// The MAC key is derived from the key of the struct, and the MAC covers the secure type and CryptoParams.
+func (c *cryptoContext) integrityMAC(v reflect.Value, cryptoParamsIndex int) ([]byte, error) {
+	var (
+		err    error
+		macKey []byte
+	)
+
+	if macKey, err = deriveKey(c.config.Key, "cryptostruct integrity"); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(c.header)
+	for i := 0; i < v.NumField(); i++ {
+		if i == cryptoParamsIndex || !v.Type().Field(i).IsExported() {
+			continue
+		}
+		writeCanonicalString(mac, v.Type().Field(i).Name)
+		if err = writeCanonical(mac, v.Field(i)); err != nil {
+			return nil, fmt.Errorf("field %s: %w", v.Type().Field(i).Name, err)
+		}
+	}
+	return mac.Sum(nil), nil
+}
+
+// verifyIntegrity verifies the MAC in params against the secure struct v
+func (c *cryptoContext) verifyIntegrity(v reflect.Value, cryptoParamsIndex int, params CryptoParams, path string) error {
+	var (
+		err      error
+		expected []byte
+		actual   []byte
+	)
+
+	if actual, err = hex.DecodeString(params.MAC); err != nil {
+		return newFieldError(path, fmt.Errorf("struct %s: could not decode MAC: %w", v.Type(), ErrIntegrity))
+	}
+	if expected, err = c.integrityMAC(v, cryptoParamsIndex); err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, actual) {
+		return newFieldError(path, fmt.Errorf("struct %s was modified: %w", v.Type(), ErrIntegrity))
+	}
+	return nil
+}
+
+// writeCanonical writes an unambiguous serialization of v to h
+func writeCanonical(h hash.Hash, v reflect.Value) error {
+	var buf [8]byte
+
+	h.Write([]byte{byte(v.Kind())})
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		binary.BigEndian.PutUint64(buf[:], uint64(v.Int()))
+		h.Write(buf[:])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		binary.BigEndian.PutUint64(buf[:], v.Uint())
+		h.Write(buf[:])
+	case reflect.Float32, reflect.Float64:
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(v.Float()))
+		h.Write(buf[:])
+	case reflect.Complex64, reflect.Complex128:
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(real(v.Complex())))
+		h.Write(buf[:])
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(imag(v.Complex())))
+		h.Write(buf[:])
+	case reflect.String:
+		writeCanonicalString(h, v.String())
+	case reflect.Slice, reflect.Array:
+		writeCanonicalLength(h, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := writeCanonical(h, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		return writeCanonicalMap(h, v)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			h.Write([]byte{0})
+			return nil
+		}
+		h.Write([]byte{1})
+		if v.Kind() == reflect.Interface {
+			writeCanonicalString(h, v.Elem().Type().String())
+		}
+		return writeCanonical(h, v.Elem())
+	case reflect.Struct:
+		return writeCanonicalStruct(h, v)
+	default:
+		return fmt.Errorf("%w %s for type %s", ErrUnsupportedKind, v.Kind(), v.Type())
+	}
+	return nil
+}
+
+func writeCanonicalStruct(h hash.Hash, v reflect.Value) error {
+	// Structs with unexported state, like time.Time, are serialized using their marshaler.
+	// A pointer to a copy is used, so marshalers with a pointer receiver are found whether or not v is addressable.
+	marshaler := reflect.New(v.Type())
+	marshaler.Elem().Set(v)
+	if m, ok := marshaler.Interface().(encoding.BinaryMarshaler); ok {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		writeCanonicalString(h, string(data))
+		return nil
+	}
+	if m, ok := marshaler.Interface().(encoding.TextMarshaler); ok {
+		data, err := m.MarshalText()
+		if err != nil {
+			return err
+		}
+		writeCanonicalString(h, string(data))
+		return nil
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		writeCanonicalString(h, v.Type().Field(i).Name)
+		if err := writeCanonical(h, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCanonicalMap writes the map entries sorted by the serialization of their keys
+func writeCanonicalMap(h hash.Hash, v reflect.Value) error {
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		keyHash := sha256.New()
+		if err := writeCanonical(keyHash, iter.Key()); err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: keyHash.Sum(nil), value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	writeCanonicalLength(h, len(entries))
+	for _, e := range entries {
+		h.Write(e.key)
+		if err := writeCanonical(h, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCanonicalString(h hash.Hash, s string) {
+	writeCanonicalLength(h, len(s))
+	h.Write([]byte(s))
+}
+
+func writeCanonicalLength(h hash.Hash, l int) {
+	h.Write(binary.AppendUvarint(nil, uint64(l)))
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"errors"
+	"math/big"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+type testPlainMarshalerData struct {
+	Name   string  `secure:"true"`
+	Link   url.URL `secure:"false"`
+	Serial big.Int `secure:"false"`
+}
+
+func (d testPlainMarshalerData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testPlainMarshalerData{},
+		Encrypted: testSecurePlainMarshalerData{},
+	}
+}
+
+type testSecurePlainMarshalerData struct {
+	Name         string  `secure:"true"`
+	Link         url.URL `secure:"false"`
+	Serial       big.Int `secure:"false"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecurePlainMarshalerData) GetTransformConfig() TransformConfig {
+	return testPlainMarshalerData{}.GetTransformConfig()
+}
+
+func (d testSecurePlainMarshalerData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func newTestIntegrityData(t *testing.T) testSecureData {
+	t.Helper()
+	encrypted, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), testData{}.GetTransformConfig(), WithIntegrity()).Transform(newTestData())
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	return encrypted.(testSecureData)
+}
+
+func TestIntegrity(t *testing.T) {
+	encrypted := newTestIntegrityData(t)
+	for _, p := range []CryptoParams{encrypted.CryptoParams, encrypted.Details.CryptoParams, encrypted.SliceDetails[1].CryptoParams} {
+		if p.MAC == "" {
+			t.Fatalf("CryptoParams = %+v, expected MAC", p)
+		}
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig(), WithIntegrity()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, newTestData()) {
+		t.Errorf("Decrypter.Transform() = %+v, want %+v", decrypted, newTestData())
+	}
+}
+
+func TestIntegrity_Modified(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *testSecureData)
+	}{
+		{
+			name: "plaintext field",
+			modify: func(d *testSecureData) {
+				d.Plain = "modified"
+			},
+		},
+		{
+			name: "plaintext field in nested struct",
+			modify: func(d *testSecureData) {
+				d.SliceDetails[0].Details.Age = 99
+			},
+		},
+		{
+			name: "removed slice element",
+			modify: func(d *testSecureData) {
+				d.NumberSlice = d.NumberSlice[:4]
+			},
+		},
+		{
+			name: "removed nested MAC",
+			modify: func(d *testSecureData) {
+				d.Details.CryptoParams.MAC = ""
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := newTestIntegrityData(t)
+			tt.modify(&encrypted)
+
+			// The MAC is verified when present, even without the option
+			_, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(encrypted)
+			if !errors.Is(err, ErrIntegrity) {
+				t.Errorf("Transform() error = %v, want %v", err, ErrIntegrity)
+			}
+		})
+	}
+}
+
+func TestIntegrity_MissingMAC(t *testing.T) {
+	encrypted := newTestEncryptedData(t)
+	if encrypted.CryptoParams.MAC != "" {
+		t.Fatalf("MAC = %s, expected no MAC without integrity option", encrypted.CryptoParams.MAC)
+	}
+
+	if _, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig()).Transform(encrypted); err != nil {
+		t.Errorf("Transform() error = %v", err)
+	}
+	_, err := NewDecrypter(testMasterKey, testData{}.GetTransformConfig(), WithIntegrity()).Transform(encrypted)
+	if !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrIntegrity)
+	}
+}
+
+func TestIntegrity_PointerReceiverMarshaler(t *testing.T) {
+	input := testPlainMarshalerData{Name: "name", Link: url.URL{Scheme: "https", Host: "example.com"}}
+	input.Serial.SetInt64(1234567890)
+
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig(), WithIntegrity()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	encrypted := output.(testSecurePlainMarshalerData)
+
+	// A struct passed by value is not addressable, the MAC must not depend on it
+	if _, err = NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted); err != nil {
+		t.Errorf("Transform() error = %v", err)
+	}
+	if _, err = NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(&encrypted); err != nil {
+		t.Errorf("Transform() with pointer error = %v", err)
+	}
+
+	// Fields which are serialized using their marshaler are protected
+	encrypted.Serial.SetInt64(1)
+	if _, err = NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Transform() error = %v, want %v", err, ErrIntegrity)
+	}
+}
+
+func TestIntegrity_OmitEmptyRemoved(t *testing.T) {
+	input := testTagOptionsData{Note: "note"}
+
+	for _, integrity := range []bool{false, true} {
+		var opts []Option
+		if integrity {
+			opts = append(opts, WithIntegrity())
+		}
+		output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig(), opts...).Transform(input)
+		if err != nil {
+			t.Fatalf("Encrypter.Transform() error = %v", err)
+		}
+		encrypted := output.(testSecureTagOptionsData)
+		encrypted.Note = ""
+
+		// Without integrity protection, the removed ciphertext of an omitempty field decrypts to the zero value
+		decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+		if integrity && !errors.Is(err, ErrIntegrity) {
+			t.Errorf("Transform() error = %v, want %v", err, ErrIntegrity)
+		}
+		if !integrity && (err != nil || decrypted.(testTagOptionsData).Note != "") {
+			t.Errorf("Transform() = %+v, %v, want empty Note", decrypted, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+const dataKeySize = 32
+
+// KeyProvider wraps and unwraps data keys, e.g. using a key management service.
+type KeyProvider interface {
+	// WrapKey encrypts dataKey and returns the wrapped key, together with the ID of the key used to wrap it
+	WrapKey(dataKey []byte) (wrappedKey []byte, keyID string, err error)
+	// UnwrapKey decrypts wrappedKey using the key with keyID, as returned by WrapKey
+	UnwrapKey(wrappedKey []byte, keyID string) ([]byte, error)
+}
+
+// NewEnvelope returns a KeySource for envelope encryption using the KeyProvider.
+// A random data key is generated for every struct, which is wrapped by the KeyProvider and stored in CryptoParams.
+// When decrypting, the KeyProvider is asked to unwrap the data key from CryptoParams.
+func NewEnvelope(k KeyProvider) KeySource {
+	return envelope{provider: k}
+}
+
+type envelope struct {
+	provider KeyProvider
+}
+
+func (e envelope) encryptionKey(p *CryptoParams) ([]byte, error) {
+	var (
+		err        error
+		wrappedKey []byte
+	)
+
+	p.clearKeySource()
+	dataKey := make([]byte, dataKeySize)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to read random data for data key: %w", err)
+	}
+
+	if wrappedKey, p.KeyID, err = e.provider.WrapKey(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	p.WrappedKey = hex.EncodeToString(wrappedKey)
+	return dataKey, nil
+}
+
+func (e envelope) decryptionKey(p CryptoParams) ([]byte, error) {
+	var (
+		err        error
+		wrappedKey []byte
+		dataKey    []byte
+	)
+
+	if p.WrappedKey == "" {
+		return nil, fmt.Errorf("wrapped key is not set")
+	}
+	if wrappedKey, err = hex.DecodeString(p.WrappedKey); err != nil {
+		return nil, fmt.Errorf("could not decode wrapped key: %w", err)
+	}
+
+	if dataKey, err = e.provider.UnwrapKey(wrappedKey, p.KeyID); err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// NewInMemoryKeyProvider returns a KeyProvider with a random key, which only lives as long as the process.
+// It is meant for testing and for data which does not need to survive a restart.
+func NewInMemoryKeyProvider() (*InMemoryKeyProvider, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to read random data for key: %w", err)
+	}
+
+	p := &InMemoryKeyProvider{}
+	p.keys = make(map[string][]byte)
+	p.add(key)
+	return p, nil
+}
+
+// InMemoryKeyProvider is a KeyProvider which wraps data keys using AES-256-GCM with a key held in memory.
+type InMemoryKeyProvider struct {
+	aesKeyProvider
+}
+
+// NewLocalFileKeyProvider returns a KeyProvider which reads its keys from files.
+// Every file must contain a hex encoded 32 byte key, e.g. created using "openssl rand -hex 32".
+// Data keys are wrapped using the key in the first file, the other files are only used to unwrap data keys
+// while keys are being rotated.
+func NewLocalFileKeyProvider(paths ...string) (*LocalFileKeyProvider, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no key files provided")
+	}
+
+	p := &LocalFileKeyProvider{}
+	p.keys = make(map[string][]byte, len(paths))
+	for i := len(paths) - 1; i >= 0; i-- {
+		var (
+			err     error
+			content []byte
+			key     []byte
+		)
+		if content, err = os.ReadFile(paths[i]); err != nil {
+			return nil, fmt.Errorf("could not read key file: %w", err)
+		}
+		if key, err = hex.DecodeString(string(bytes.TrimSpace(content))); err != nil {
+			return nil, fmt.Errorf("could not decode key file %s: %w", paths[i], err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("invalid key length %d in key file %s, expected %d bytes", len(key), paths[i], dataKeySize)
+		}
+		p.add(key)
+	}
+	return p, nil
+}
+
+// LocalFileKeyProvider is a KeyProvider which wraps data keys using AES-256-GCM with keys read from local files.
+type LocalFileKeyProvider struct {
+	aesKeyProvider
+}
+
+// aesKeyProvider wraps data keys using AES-256-GCM. Keys are identified by the fingerprint of the key.
+type aesKeyProvider struct {
+	keys   map[string][]byte
+	active string
+}
+
+// add adds key to the provider and makes it the active key
+func (p *aesKeyProvider) add(key []byte) {
+	fingerprint := sha256.Sum256(key)
+	id := hex.EncodeToString(fingerprint[:8])
+	p.keys[id] = key
+	p.active = id
+}
+
+func (p *aesKeyProvider) WrapKey(dataKey []byte) ([]byte, string, error) {
+	var (
+		err   error
+		aead  cipher.AEAD
+		nonce []byte
+	)
+
+	if aead, err = p.getAEAD(p.active); err != nil {
+		return nil, "", err
+	}
+
+	nonce = make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, "", fmt.Errorf("failed to read random data for nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(p.active)), p.active, nil
+}
+
+func (p *aesKeyProvider) UnwrapKey(wrappedKey []byte, keyID string) ([]byte, error) {
+	var (
+		err     error
+		aead    cipher.AEAD
+		dataKey []byte
+	)
+
+	if aead, err = p.getAEAD(keyID); err != nil {
+		return nil, err
+	}
+
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	nonce, ciphertext := wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():]
+	if dataKey, err = aead.Open(nil, nonce, ciphertext, []byte(keyID)); err != nil {
+		return nil, fmt.Errorf("failed to decrypt wrapped key: %w", err)
+	}
+	return dataKey, nil
+}
+
+func (p *aesKeyProvider) getAEAD(keyID string) (cipher.AEAD, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestKeyFile(t *testing.T, name string, keyHex string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(keyHex+"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestEnvelope_InMemoryKeyProvider(t *testing.T) {
+	input := newTestData()
+	provider, err := NewInMemoryKeyProvider()
+	if err != nil {
+		t.Fatalf("NewInMemoryKeyProvider() error = %v", err)
+	}
+	keys := NewEnvelope(provider)
+
+	output, err := NewEncrypterWithKeySource(keys, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	// Every struct uses its own data key
+	encrypted := output.(testSecureData)
+	wrappedKeys := make(map[string]bool)
+	for _, p := range []CryptoParams{encrypted.CryptoParams, encrypted.Details.CryptoParams, encrypted.SliceDetails[0].CryptoParams} {
+		if p.WrappedKey == "" || p.KeyID == "" {
+			t.Fatalf("CryptoParams = %+v, expected wrapped key and key ID", p)
+		}
+		if wrappedKeys[p.WrappedKey] {
+			t.Errorf("wrapped key %s is reused", p.WrappedKey)
+		}
+		wrappedKeys[p.WrappedKey] = true
+	}
+
+	decrypted, err := NewDecrypterWithKeySource(keys, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Decrypter.Transform() = %+v, want %+v", decrypted, input)
+	}
+
+	// Another provider cannot unwrap the data keys
+	other, err := NewInMemoryKeyProvider()
+	if err != nil {
+		t.Fatalf("NewInMemoryKeyProvider() error = %v", err)
+	}
+	if _, err = NewDecrypterWithKeySource(NewEnvelope(other), input.GetTransformConfig()).Transform(encrypted); err == nil {
+		t.Error("Decrypter.Transform() expected error for other key provider")
+	}
+}
+
+func TestEnvelope_LocalFileKeyProvider(t *testing.T) {
+	input := newTestData()
+	oldPath := writeTestKeyFile(t, "old.key", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	newPath := writeTestKeyFile(t, "new.key", "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100")
+
+	oldProvider, err := NewLocalFileKeyProvider(oldPath)
+	if err != nil {
+		t.Fatalf("NewLocalFileKeyProvider() error = %v", err)
+	}
+	encrypted, err := NewEncrypterWithKeySource(NewEnvelope(oldProvider), newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	// The new provider wraps using the new key, but can still unwrap keys wrapped with the old key
+	newProvider, err := NewLocalFileKeyProvider(newPath, oldPath)
+	if err != nil {
+		t.Fatalf("NewLocalFileKeyProvider() error = %v", err)
+	}
+	decrypted, err := NewDecrypterWithKeySource(NewEnvelope(newProvider), input.GetTransformConfig()).Transform(encrypted.(testSecureData))
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Decrypter.Transform() = %+v, want %+v", decrypted, input)
+	}
+
+	_, newKeyID, err := newProvider.WrapKey(make([]byte, dataKeySize))
+	if err != nil {
+		t.Fatalf("WrapKey() error = %v", err)
+	}
+	if newKeyID == encrypted.(testSecureData).CryptoParams.KeyID {
+		t.Error("WrapKey() did not use the key from the first file")
+	}
+}
+
+func TestNewLocalFileKeyProvider_Errors(t *testing.T) {
+	if _, err := NewLocalFileKeyProvider(); err == nil {
+		t.Error("NewLocalFileKeyProvider() expected error without files")
+	}
+	if _, err := NewLocalFileKeyProvider(filepath.Join(t.TempDir(), "missing.key")); err == nil {
+		t.Error("NewLocalFileKeyProvider() expected error for missing file")
+	}
+	if _, err := NewLocalFileKeyProvider(writeTestKeyFile(t, "short.key", "0011")); err == nil {
+		t.Error("NewLocalFileKeyProvider() expected error for short key")
+	}
+}
+
+func TestEnvelope_MissingWrappedKey(t *testing.T) {
+	provider, err := NewInMemoryKeyProvider()
+	if err != nil {
+		t.Fatalf("NewInMemoryKeyProvider() error = %v", err)
+	}
+	if _, err = NewEnvelope(provider).decryptionKey(newTestCryptoParams(t)); err == nil {
+		t.Error("decryptionKey() expected error without wrapped key")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// KeySource provides the master keys used by Encrypter and Decrypter.
+type KeySource interface {
+	// encryptionKey returns the master key to encrypt a struct and records which key is used in p
+	encryptionKey(p *CryptoParams) ([]byte, error)
+	// decryptionKey returns the master key to decrypt a struct which was encrypted using p
+	decryptionKey(p CryptoParams) ([]byte, error)
+}
+
+// NewMasterKey returns a KeySource for a single hex encoded master key.
+func NewMasterKey(masterKeyHex string) KeySource {
+	return masterKey(masterKeyHex)
+}
+
+// masterKey is a KeySource for a single hex encoded master key, which is used regardless of the key ID in CryptoParams.
+type masterKey string
+
+func (k masterKey) encryptionKey(p *CryptoParams) ([]byte, error) {
+	p.clearKeySource()
+	return k.decode()
+}
+
+func (k masterKey) decryptionKey(_ CryptoParams) ([]byte, error) {
+	return k.decode()
+}
+
+func (k masterKey) decode() ([]byte, error) {
+	key, err := hex.DecodeString(string(k))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode masterKeyHex key: %w", err)
+	}
+	return key, nil
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string][]byte),
+	}
+}
+
+// Keyring is a KeySource holding multiple master keys, identified by a key ID.
+// New structs are encrypted using the active key, and the ID of that key is stored in CryptoParams.
+// When decrypting, the key is selected using the key ID in CryptoParams, so ciphertexts created with
+// different keys can be decrypted while the master key is being rotated.
+// Ciphertexts without a key ID are decrypted using the key with an empty ID, if it was added to the Keyring.
+type Keyring struct {
+	mux    sync.RWMutex
+	keys   map[string][]byte
+	active string
+}
+
+// AddKey adds the hex encoded master key to the Keyring using id.
+func (k *Keyring) AddKey(id string, masterKeyHex string) error {
+	key, err := hex.DecodeString(masterKeyHex)
+	if err != nil {
+		return fmt.Errorf("could not decode master key %s: %w", id, err)
+	}
+
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("key %s already exists", id)
+	}
+	k.keys[id] = key
+	return nil
+}
+
+// RemoveKey removes the key with id from the Keyring. The active key cannot be removed.
+func (k *Keyring) RemoveKey(id string) error {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	if k.active == id {
+		return fmt.Errorf("key %s is the active key", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// SetActive marks the key with id as the key to use for encryption.
+func (k *Keyring) SetActive(id string) error {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	k.active = id
+	return nil
+}
+
+// Active returns the ID of the key used for encryption.
+func (k *Keyring) Active() string {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	return k.active
+}
+
+func (k *Keyring) encryptionKey(p *CryptoParams) ([]byte, error) {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	key, ok := k.keys[k.active]
+	if !ok {
+		return nil, fmt.Errorf("keyring has no active key")
+	}
+	p.clearKeySource()
+	p.KeyID = k.active
+	return key, nil
+}
+
+func (k *Keyring) decryptionKey(p CryptoParams) ([]byte, error) {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	key, ok := k.keys[p.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w in keyring: %s", ErrKeyNotFound, p.KeyID)
+	}
+	return key, nil
+}
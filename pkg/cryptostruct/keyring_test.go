@@ -0,0 +1,127 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	k := NewKeyring()
+	for _, id := range ids {
+		if err := k.AddKey(id, hex.EncodeToString([]byte("masterKey-"+id))); err != nil {
+			t.Fatalf("AddKey() error = %v", err)
+		}
+	}
+	return k
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	input := newTestData()
+	keyring := newTestKeyring(t, "v1", "v2")
+
+	if err := keyring.SetActive("v1"); err != nil {
+		t.Fatalf("SetActive() error = %v", err)
+	}
+	oldOutput, err := NewEncrypterWithKeySource(keyring, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	if err = keyring.SetActive("v2"); err != nil {
+		t.Fatalf("SetActive() error = %v", err)
+	}
+	newOutput, err := NewEncrypterWithKeySource(keyring, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+
+	oldEncrypted := oldOutput.(testSecureData)
+	newEncrypted := newOutput.(testSecureData)
+	if oldEncrypted.CryptoParams.KeyID != "v1" || oldEncrypted.Details.CryptoParams.KeyID != "v1" {
+		t.Errorf("old KeyID = %s, want v1", oldEncrypted.CryptoParams.KeyID)
+	}
+	if newEncrypted.CryptoParams.KeyID != "v2" || newEncrypted.SliceDetails[0].CryptoParams.KeyID != "v2" {
+		t.Errorf("new KeyID = %s, want v2", newEncrypted.CryptoParams.KeyID)
+	}
+
+	// Both ciphertexts can be decrypted while the keyring holds both keys
+	decrypter := NewDecrypterWithKeySource(keyring, input.GetTransformConfig())
+	for _, encrypted := range []testSecureData{oldEncrypted, newEncrypted} {
+		decrypted, err := decrypter.Transform(encrypted)
+		if err != nil {
+			t.Fatalf("Decrypter.Transform() error = %v", err)
+		}
+		if !reflect.DeepEqual(decrypted, input) {
+			t.Errorf("Decrypter.Transform() = %+v, want %+v", decrypted, input)
+		}
+	}
+
+	// Once the old key is removed, old ciphertexts can no longer be decrypted
+	if err = keyring.RemoveKey("v1"); err != nil {
+		t.Fatalf("RemoveKey() error = %v", err)
+	}
+	if _, err = decrypter.Transform(oldEncrypted); err == nil {
+		t.Error("Decrypter.Transform() expected error for removed key")
+	}
+}
+
+func TestKeyring_LegacyKey(t *testing.T) {
+	input := newTestData()
+	encrypted, err := NewEncrypter(hex.EncodeToString([]byte("masterKey-")), newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	if encrypted.(testSecureData).CryptoParams.KeyID != "" {
+		t.Errorf("KeyID = %s, want empty", encrypted.(testSecureData).CryptoParams.KeyID)
+	}
+
+	// Ciphertexts without key ID use the key with an empty ID
+	keyring := newTestKeyring(t, "", "v1")
+	if _, err = NewDecrypterWithKeySource(keyring, input.GetTransformConfig()).Transform(encrypted.(testSecureData)); err != nil {
+		t.Errorf("Decrypter.Transform() error = %v", err)
+	}
+}
+
+func TestKeyring_Errors(t *testing.T) {
+	keyring := newTestKeyring(t, "v1")
+
+	if err := keyring.AddKey("v1", testMasterKey); err == nil {
+		t.Error("AddKey() expected error for duplicate id")
+	}
+	if err := keyring.AddKey("v2", "not-hex"); err == nil {
+		t.Error("AddKey() expected error for invalid key")
+	}
+	if err := keyring.SetActive("unknown"); err == nil {
+		t.Error("SetActive() expected error for unknown id")
+	}
+
+	p := newTestCryptoParams(t)
+	if _, err := keyring.encryptionKey(&p); err == nil {
+		t.Error("encryptionKey() expected error without active key")
+	}
+
+	if err := keyring.SetActive("v1"); err != nil {
+		t.Fatalf("SetActive() error = %v", err)
+	}
+	if err := keyring.RemoveKey("v1"); err == nil {
+		t.Error("RemoveKey() expected error for active key")
+	}
+}
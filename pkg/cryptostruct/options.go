@@ -0,0 +1,74 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+// Option configures an Encrypter or Decrypter.
+type Option func(o *options)
+
+type options struct {
+	integrity     bool
+	collectErrors bool
+	strict        bool
+	encoding      string
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithIntegrity enables integrity protection of the whole struct.
+// An Encrypter computes a MAC over all fields of every struct, including the fields which are not encrypted,
+// and stores it in CryptoParams. A Decrypter refuses structs without a MAC.
+// A Decrypter always verifies the MAC when it is present, regardless of this option.
+func WithIntegrity() Option {
+	return func(o *options) {
+		o.integrity = true
+	}
+}
+
+// WithCollectErrors makes a Decrypter continue when a field cannot be decrypted.
+// Transform returns the partially decrypted struct, in which the failed fields have their zero value,
+// together with the FieldError of every failed field joined using errors.Join.
+// Errors which affect the whole struct, such as a failed integrity check of the top-level struct, still abort.
+// An Encrypter ignores this option.
+func WithCollectErrors() Option {
+	return func(o *options) {
+		o.collectErrors = true
+	}
+}
+
+// WithStrict requires every field of every struct to declare secure:"true" or secure:"false", so a newly added field
+// cannot be copied in plaintext by accident. Transform fails with ErrUntaggedField, listing all fields without tag.
+// The fields of embedded structs without tag are checked as if they were declared on the embedding struct.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithEncoding sets the encoding of the ciphertexts which are stored in strings to EncodingHex, EncodingBase64 or
+// EncodingBase64URL, overriding the Encoding of the CryptoParams passed to an Encrypter.
+// The encoding is recorded in the CryptoParams of every struct, so a Decrypter ignores this option.
+func WithEncoding(encoding string) Option {
+	return func(o *options) {
+		o.encoding = encoding
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+
+	saltSize = 16
+
+	// KDF parameters are read from CryptoParams, which can be modified by anyone with write access to the stored data.
+	// The memory used by a single derivation and its total cost, the memory multiplied by the number of passes over it,
+	// are bounded to limit the resources a modified document can make the decrypter use.
+	maxKDFMemory       = 1 << 30
+	maxKDFCost         = 4 * maxKDFMemory
+	maxArgon2idTime    = 64
+	maxArgon2idThreads = 64
+	maxScryptP         = 64
+
+	// Maximum number of keys derived for KDFParams read from CryptoParams which are cached by a passphrase KeySource
+	maxCachedPassphraseKeys = 16
+)
+
+// DefaultArgon2idParams returns the recommended Argon2id parameters from RFC 9106: 3 passes over 64 MiB of memory using 4 threads.
+func DefaultArgon2idParams() KDFParams {
+	return KDFParams{
+		Name:    KDFArgon2id,
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+}
+
+// DefaultScryptParams returns the recommended scrypt parameters for interactive use: N=32768, r=8, p=1.
+func DefaultScryptParams() KDFParams {
+	return KDFParams{
+		Name: KDFScrypt,
+		N:    32768,
+		R:    8,
+		P:    1,
+	}
+}
+
+// KDFParams holds the name and parameters of the key derivation function used to derive a master key from a passphrase.
+// Time, Memory (in KiB) and Threads are used by Argon2id, N, R and P are used by scrypt.
+type KDFParams struct {
+	Name    string `json:"name" yaml:"name" mapstructure:"name"`
+	Salt    string `json:"salt" yaml:"salt" mapstructure:"salt"`
+	Time    uint32 `json:"time,omitempty" yaml:"time,omitempty" mapstructure:"time"`
+	Memory  uint32 `json:"memory,omitempty" yaml:"memory,omitempty" mapstructure:"memory"`
+	Threads uint8  `json:"threads,omitempty" yaml:"threads,omitempty" mapstructure:"threads"`
+	N       int    `json:"n,omitempty" yaml:"n,omitempty" mapstructure:"n"`
+	R       int    `json:"r,omitempty" yaml:"r,omitempty" mapstructure:"r"`
+	P       int    `json:"p,omitempty" yaml:"p,omitempty" mapstructure:"p"`
+}
+
+func (k KDFParams) validate() error {
+	switch k.Name {
+	case KDFArgon2id:
+		if k.Time == 0 || k.Time > maxArgon2idTime {
+			return fmt.Errorf("invalid argon2id time %d", k.Time)
+		}
+		if k.Threads == 0 || k.Threads > maxArgon2idThreads {
+			return fmt.Errorf("invalid argon2id threads %d", k.Threads)
+		}
+		// Memory is in KiB
+		memory := uint64(k.Memory) * 1024
+		if k.Memory < 8*uint32(k.Threads) || memory > maxKDFMemory {
+			return fmt.Errorf("invalid argon2id memory %d", k.Memory)
+		}
+		if memory*uint64(k.Time) > maxKDFCost {
+			return fmt.Errorf("argon2id time %d over %d KiB of memory exceeds the maximum cost", k.Time, k.Memory)
+		}
+	case KDFScrypt:
+		if k.N <= 1 || k.N > maxKDFMemory/128 || k.N&(k.N-1) != 0 {
+			return fmt.Errorf("invalid scrypt N %d, must be a power of 2", k.N)
+		}
+		if k.R <= 0 || k.R > maxKDFMemory/128 {
+			return fmt.Errorf("invalid scrypt r %d", k.R)
+		}
+		if k.P <= 0 || k.P > maxScryptP {
+			return fmt.Errorf("invalid scrypt p %d", k.P)
+		}
+		// scrypt uses 128·N·r bytes of memory, once for every p
+		memory := 128 * uint64(k.N) * uint64(k.R)
+		if memory > maxKDFMemory {
+			return fmt.Errorf("scrypt N %d and r %d exceed the maximum memory", k.N, k.R)
+		}
+		if memory*uint64(k.P) > maxKDFCost {
+			return fmt.Errorf("scrypt N %d, r %d and p %d exceed the maximum cost", k.N, k.R, k.P)
+		}
+	default:
+		return fmt.Errorf("invalid key derivation function %s", k.Name)
+	}
+	return nil
+}
+
+func (k KDFParams) deriveKey(passphrase []byte) ([]byte, error) {
+	var (
+		err  error
+		salt []byte
+	)
+
+	if err = k.validate(); err != nil {
+		return nil, err
+	}
+	if salt, err = hex.DecodeString(k.Salt); err != nil {
+		return nil, fmt.Errorf("could not decode salt: %w", err)
+	}
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("salt is not set")
+	}
+
+	switch k.Name {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, salt, k.Time, k.Memory, k.Threads, dataKeySize), nil
+	default:
+		return scrypt.Key(passphrase, salt, k.N, k.R, k.P, dataKeySize)
+	}
+}
+
+// NewPassphrase returns a KeySource which derives the master key from passphrase using the key derivation function in p.
+// A random salt is generated, and the name, parameters and salt of the key derivation function are stored in CryptoParams,
+// so the master key can be derived again when decrypting.
+// The salt in p is ignored, use DefaultArgon2idParams or DefaultScryptParams for recommended parameters.
+func NewPassphrase(passphrase string, p KDFParams) (KeySource, error) {
+	var (
+		err  error
+		salt [saltSize]byte
+	)
+
+	if err = p.validate(); err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, fmt.Errorf("failed to read random data for salt: %w", err)
+	}
+	p.Salt = hex.EncodeToString(salt[:])
+
+	return &passphraseKey{
+		passphrase: []byte(passphrase),
+		params:     p,
+	}, nil
+}
+
+// passphraseKey is a KeySource deriving master keys from a passphrase.
+// Derived keys are cached, as key derivation is expensive by design. The KDFParams used for decrypting are read from
+// CryptoParams, so only a limited number of them is cached, next to the KDFParams used for encrypting.
+type passphraseKey struct {
+	passphrase []byte
+	params     KDFParams
+	mu         sync.Mutex
+	key        []byte
+	keys       map[KDFParams][]byte
+}
+
+func (k *passphraseKey) encryptionKey(p *CryptoParams) ([]byte, error) {
+	p.clearKeySource()
+	params := k.params
+	p.KDF = &params
+	return k.getKey(params)
+}
+
+func (k *passphraseKey) decryptionKey(p CryptoParams) ([]byte, error) {
+	if p.KDF == nil {
+		return nil, fmt.Errorf("key derivation parameters are not set")
+	}
+	return k.getKey(*p.KDF)
+}
+
+func (k *passphraseKey) getKey(params KDFParams) ([]byte, error) {
+	if key, ok := k.cachedKey(params); ok {
+		return key, nil
+	}
+
+	// Derive the key without holding the lock, concurrent derivations of the same key are harmless
+	key, err := params.deriveKey(k.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive master key from passphrase: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if params == k.params {
+		k.key = key
+		return key, nil
+	}
+	if k.keys == nil {
+		k.keys = make(map[KDFParams][]byte)
+	}
+	// Evict an arbitrary key when the cache is full
+	if len(k.keys) >= maxCachedPassphraseKeys {
+		for cached := range k.keys {
+			delete(k.keys, cached)
+			break
+		}
+	}
+	k.keys[params] = key
+	return key, nil
+}
+
+func (k *passphraseKey) cachedKey(params KDFParams) ([]byte, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if params == k.params {
+		return k.key, k.key != nil
+	}
+	key, ok := k.keys[params]
+	return key, ok
+}
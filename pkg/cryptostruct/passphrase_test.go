@@ -0,0 +1,180 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Cheap parameters to keep the tests fast
+var (
+	testArgon2idParams = KDFParams{Name: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+	testScryptParams   = KDFParams{Name: KDFScrypt, N: 1024, R: 8, P: 1}
+)
+
+func TestPassphrase(t *testing.T) {
+	for _, params := range []KDFParams{testArgon2idParams, testScryptParams} {
+		t.Run(params.Name, func(t *testing.T) {
+			input := newTestData()
+			keys, err := NewPassphrase("correct horse battery staple", params)
+			if err != nil {
+				t.Fatalf("NewPassphrase() error = %v", err)
+			}
+
+			output, err := NewEncrypterWithKeySource(keys, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+			if err != nil {
+				t.Fatalf("Encrypter.Transform() error = %v", err)
+			}
+			encrypted := output.(testSecureData)
+
+			kdf := encrypted.CryptoParams.KDF
+			if kdf == nil || kdf.Name != params.Name || kdf.Salt == "" {
+				t.Fatalf("KDF = %+v, expected %s with salt", kdf, params.Name)
+			}
+			if *encrypted.Details.CryptoParams.KDF != *kdf {
+				t.Errorf("nested KDF = %+v, want %+v", encrypted.Details.CryptoParams.KDF, kdf)
+			}
+
+			// A new KeySource with the same passphrase uses the parameters from CryptoParams
+			decryptKeys, err := NewPassphrase("correct horse battery staple", params)
+			if err != nil {
+				t.Fatalf("NewPassphrase() error = %v", err)
+			}
+			decrypted, err := NewDecrypterWithKeySource(decryptKeys, input.GetTransformConfig()).Transform(encrypted)
+			if err != nil {
+				t.Fatalf("Decrypter.Transform() error = %v", err)
+			}
+			if !reflect.DeepEqual(decrypted, input) {
+				t.Errorf("Decrypter.Transform() = %+v, want %+v", decrypted, input)
+			}
+
+			wrongKeys, err := NewPassphrase("wrong passphrase", params)
+			if err != nil {
+				t.Fatalf("NewPassphrase() error = %v", err)
+			}
+			if _, err = NewDecrypterWithKeySource(wrongKeys, input.GetTransformConfig()).Transform(encrypted); err == nil {
+				t.Error("Decrypter.Transform() expected error for wrong passphrase")
+			}
+		})
+	}
+}
+
+func TestPassphrase_RandomSalt(t *testing.T) {
+	first, err := NewPassphrase("passphrase", testArgon2idParams)
+	if err != nil {
+		t.Fatalf("NewPassphrase() error = %v", err)
+	}
+	second, err := NewPassphrase("passphrase", testArgon2idParams)
+	if err != nil {
+		t.Fatalf("NewPassphrase() error = %v", err)
+	}
+	if first.(*passphraseKey).params.Salt == second.(*passphraseKey).params.Salt {
+		t.Error("NewPassphrase() reused the salt")
+	}
+}
+
+func TestPassphrase_CacheBounded(t *testing.T) {
+	keys, err := NewPassphrase("passphrase", testArgon2idParams)
+	if err != nil {
+		t.Fatalf("NewPassphrase() error = %v", err)
+	}
+	k := keys.(*passphraseKey)
+	key, err := k.getKey(k.params)
+	if err != nil {
+		t.Fatalf("getKey() error = %v", err)
+	}
+
+	// KDFParams read from CryptoParams are attacker controlled, every distinct salt derives another key
+	for i := 0; i < 2*maxCachedPassphraseKeys; i++ {
+		params := testArgon2idParams
+		params.Salt = fmt.Sprintf("%032x", i)
+		if _, err = k.decryptionKey(CryptoParams{KDF: &params}); err != nil {
+			t.Fatalf("decryptionKey() error = %v", err)
+		}
+	}
+	if len(k.keys) > maxCachedPassphraseKeys {
+		t.Errorf("cached %d keys, want at most %d", len(k.keys), maxCachedPassphraseKeys)
+	}
+	if cached, ok := k.cachedKey(k.params); !ok || !bytes.Equal(cached, key) {
+		t.Error("key for the encryption parameters was evicted from the cache")
+	}
+}
+
+func TestEncryptWithPassphrase(t *testing.T) {
+	input := newTestData()
+	encrypted, err := EncryptWithPassphrase("passphrase", newTestCryptoParams(t), input)
+	if err != nil {
+		t.Fatalf("EncryptWithPassphrase() error = %v", err)
+	}
+	if kdf := encrypted.(testSecureData).CryptoParams.KDF; kdf == nil || *kdf != (KDFParams{Name: KDFArgon2id, Salt: kdf.Salt, Time: 3, Memory: 64 * 1024, Threads: 4}) {
+		t.Errorf("KDF = %+v, expected default argon2id parameters", kdf)
+	}
+
+	decrypted, err := DecryptWithPassphrase("passphrase", encrypted.(testSecureData))
+	if err != nil {
+		t.Fatalf("DecryptWithPassphrase() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("DecryptWithPassphrase() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestKDFParams_Validate(t *testing.T) {
+	// 1 GiB of memory, with a total cost of 4 GiB
+	valid := []KDFParams{
+		DefaultArgon2idParams(),
+		DefaultScryptParams(),
+		{Name: KDFArgon2id, Time: 4, Memory: 1 << 20, Threads: 4},
+		{Name: KDFArgon2id, Time: 64, Memory: 1 << 16, Threads: 4},
+		{Name: KDFScrypt, N: 1 << 20, R: 8, P: 4},
+		{Name: KDFScrypt, N: 1 << 14, R: 512, P: 4},
+	}
+	for _, params := range valid {
+		if err := params.validate(); err != nil {
+			t.Errorf("validate(%+v) error = %v", params, err)
+		}
+	}
+
+	invalid := []KDFParams{
+		{Name: "pbkdf2"},
+		{Name: KDFArgon2id, Time: 0, Memory: 64, Threads: 1},
+		{Name: KDFArgon2id, Time: 1, Memory: 1<<20 + 1, Threads: 1},
+		{Name: KDFArgon2id, Time: 5, Memory: 1 << 20, Threads: 4},
+		{Name: KDFArgon2id, Time: 64, Memory: 4 * 1024 * 1024, Threads: 64},
+		{Name: KDFScrypt, N: 1000, R: 8, P: 1},
+		{Name: KDFScrypt, N: 1 << 20, R: 9, P: 1},
+		{Name: KDFScrypt, N: 1 << 21, R: 8, P: 1},
+		{Name: KDFScrypt, N: 1 << 20, R: 8, P: 5},
+		{Name: KDFScrypt, N: 1 << 22, R: 64, P: 64},
+	}
+	for _, params := range invalid {
+		if err := params.validate(); err == nil {
+			t.Errorf("validate(%+v) expected error", params)
+		}
+	}
+
+	keys, err := NewPassphrase("passphrase", testScryptParams)
+	if err != nil {
+		t.Fatalf("NewPassphrase() error = %v", err)
+	}
+	if _, err = keys.decryptionKey(newTestCryptoParams(t)); err == nil {
+		t.Error("decryptionKey() expected error without KDF parameters")
+	}
+}
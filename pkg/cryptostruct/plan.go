@@ -0,0 +1,505 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	cryptoParamsType       = reflect.TypeOf(CryptoParams{})
+	encryptTransformerType = reflect.TypeOf((*EncryptTransformer)(nil)).Elem()
+	decryptTransformerType = reflect.TypeOf((*DecryptTransformer)(nil)).Elem()
+	binaryMarshalerType    = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType  = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType      = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType    = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Compiled plans are cached per combination of input type, output type and transformMode
+var plans sync.Map
+
+type transformMode int
+
+const (
+	encryptMode transformMode = iota
+	decryptMode
+)
+
+func (m transformMode) String() string {
+	if m == encryptMode {
+		return "encrypt"
+	}
+	return "decrypt"
+}
+
+// transformerType returns the interface which must be implemented by nested structs for the transformMode
+func (m transformMode) transformerType() reflect.Type {
+	if m == encryptMode {
+		return encryptTransformerType
+	}
+	return decryptTransformerType
+}
+
+type planKey struct {
+	input  reflect.Type
+	output reflect.Type
+	mode   transformMode
+}
+
+// structPlan holds all decisions needed to transform a struct of type input into a struct of type output.
+// In encryptMode, input is the plain type and output is the secure type, in decryptMode it is the other way around.
+type structPlan struct {
+	input  reflect.Type
+	output reflect.Type
+	mode   transformMode
+	// Index of the CryptoParams field on the secure type
+	cryptoParamsIndex int
+	fields            []fieldPlan
+	// Names of the fields of input without secure tag, which are rejected in strict mode
+	untagged []string
+	// The struct has fields which could not be encrypted before version 1, so their ciphertexts are always bound
+	// to their field, regardless of the version in the CryptoParams
+	requiresBinding bool
+}
+
+type fieldPlan struct {
+	// Name of the field on the secure type, used in the path of the field
+	name string
+	// Index sequences of the field, which have more than one element for fields of flattened embedded structs
+	inputIndex  []int
+	outputIndex []int
+	tag         Tag
+	// value is nil if the field is not enabled and must be copied as-is
+	value *valuePlan
+}
+
+type valueOp int
+
+const (
+	opScalar valueOp = iota
+	opTransformer
+	opSlice
+	opMap
+	opPointer
+	opArray
+	opBytes
+	opMarshaler
+	opInterface
+)
+
+type valuePlan struct {
+	op     valueOp
+	input  reflect.Type
+	output reflect.Type
+	// Plan for the elements of a slice or array, the values of a map or the value a pointer points to
+	elem *valuePlan
+	// Plan for the keys of a map, nil if the keys are copied as-is
+	key *valuePlan
+	// Marshalers are serialized using encoding.TextMarshaler instead of encoding.BinaryMarshaler
+	text bool
+}
+
+// getPlan returns the cached plan to transform input into output, compiling it when it is requested for the first time.
+func getPlan(input reflect.Type, output reflect.Type, mode transformMode) (*structPlan, error) {
+	key := planKey{input: input, output: output, mode: mode}
+	if p, ok := plans.Load(key); ok {
+		return p.(*structPlan), nil
+	}
+
+	p, err := compilePlan(input, output, mode)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := plans.LoadOrStore(key, p)
+	return actual.(*structPlan), nil
+}
+
+// compilePlan compiles the plan to transform input into output.
+// All invalid fields are reported as *ValidationError, joined into a single error. The returned plan contains the
+// valid fields, it is nil if the types themselves cannot be transformed.
+func compilePlan(input reflect.Type, output reflect.Type, mode transformMode) (*structPlan, error) {
+	var (
+		errs       []error
+		secureType reflect.Type
+	)
+
+	if input.Kind() != reflect.Struct || output.Kind() != reflect.Struct {
+		return nil, &ValidationError{Type: input, Err: fmt.Errorf("cannot %s %s into %s: both types must be structs", mode, input, output)}
+	}
+
+	p := &structPlan{
+		input:             input,
+		output:            output,
+		mode:              mode,
+		cryptoParamsIndex: -1,
+		fields:            make([]fieldPlan, 0, input.NumField()),
+	}
+
+	secureType = output
+	if mode == decryptMode {
+		secureType = input
+	}
+	for i := 0; i < secureType.NumField(); i++ {
+		if secureType.Field(i).Type == cryptoParamsType {
+			p.cryptoParamsIndex = i
+			break
+		}
+	}
+	if p.cryptoParamsIndex < 0 {
+		return nil, &ValidationError{Type: secureType, Err: fmt.Errorf("secure type has no field of type CryptoParams")}
+	}
+
+	// Process all fields in the input, including the fields promoted from flattened embedded structs
+	flattened := make(map[string]bool)
+	for _, inputField := range reflect.VisibleFields(input) {
+		// Promoted fields are only processed when the embedded struct is flattened
+		if len(inputField.Index) > 1 && !flattened[fmt.Sprint(inputField.Index[:len(inputField.Index)-1])] {
+			continue
+		}
+
+		// CryptoParams must not be stored in the output
+		if mode == decryptMode && len(inputField.Index) == 1 && inputField.Index[0] == p.cryptoParamsIndex {
+			continue
+		}
+
+		tag, tagged, err := getTag(inputField)
+		if err != nil {
+			errs = append(errs, &ValidationError{Type: input, Field: inputField.Name, Err: err})
+			continue
+		}
+
+		// Embedded structs without secure tag are flattened, their fields are processed as if they were declared on the input
+		if inputField.Anonymous && !tagged && inputField.Type.Kind() == reflect.Struct {
+			flattened[fmt.Sprint(inputField.Index)] = true
+			continue
+		}
+
+		if !tagged {
+			p.untagged = append(p.untagged, inputField.Name)
+		}
+
+		var f *fieldPlan
+		if f, err = compileFieldPlan(inputField, tag, tagged, output, mode); err != nil {
+			errs = append(errs, &ValidationError{Type: input, Field: inputField.Name, Err: err})
+			continue
+		}
+		p.fields = append(p.fields, *f)
+		if f.value != nil && (f.tag.hasCryptoOptions() || !isUnboundValue(f.value, mode)) {
+			p.requiresBinding = true
+		}
+	}
+	if len(errs) > 0 {
+		return p, errors.Join(errs...)
+	}
+	return p, nil
+}
+
+// checkStrict returns an error listing the fields of the input without secure tag
+func (p *structPlan) checkStrict() error {
+	if len(p.untagged) == 0 {
+		return nil
+	}
+	return &ValidationError{Type: p.input, Err: fmt.Errorf("%w: %s", ErrUntaggedField, strings.Join(p.untagged, ", "))}
+}
+
+func compileFieldPlan(inputField reflect.StructField, tag Tag, tagged bool, output reflect.Type, mode transformMode) (*fieldPlan, error) {
+	var err error
+
+	if inputField.Anonymous && !tagged && inputField.Type.Kind() == reflect.Pointer {
+		return nil, fmt.Errorf("embedded pointer cannot be flattened, add a secure tag")
+	}
+	// Only the promoted fields of embedded structs with an unexported type can be set
+	if inputField.Anonymous && !inputField.IsExported() {
+		return nil, fmt.Errorf("embedded field has an unexported type and can only be flattened")
+	}
+	if !inputField.IsExported() && !inputField.Anonymous {
+		return nil, fmt.Errorf("unexported fields cannot be transformed")
+	}
+
+	outputField, ok := findOutputField(inputField, tag, output, mode)
+	if !ok {
+		return nil, fmt.Errorf("not found in %s", output)
+	}
+	if err = checkIndexPath(output, outputField.Index); err != nil {
+		return nil, fmt.Errorf("field %s of %s %w", outputField.Name, output, err)
+	}
+	// The options in the tag affect the ciphertext, so both types must declare the same options
+	if outputTag, outputTagged, _ := getTag(outputField); outputTagged && outputTag != tag {
+		return nil, fmt.Errorf("secure tag %q does not match %q in %s", inputField.Tag.Get("secure"), outputField.Tag.Get("secure"), output)
+	}
+
+	// Ciphertexts are bound to the name of the field on the secure type, which differs for embedded secure twins
+	name := outputField.Name
+	if mode == decryptMode {
+		name = inputField.Name
+	}
+
+	f := &fieldPlan{
+		name:        name,
+		inputIndex:  inputField.Index,
+		outputIndex: outputField.Index,
+		tag:         tag,
+	}
+
+	if f.tag.Enabled {
+		if f.value, err = compileValuePlan(inputField.Type, outputField.Type, mode); err != nil {
+			return nil, err
+		}
+		// Nested structs are encrypted using their own CryptoParams and tags
+		if tag.hasCryptoOptions() && containsTransformer(f.value) {
+			return nil, fmt.Errorf("options key, encoding and deterministic cannot be used for nested structs")
+		}
+	} else if inputField.Type != outputField.Type {
+		// If the field must not be transformed, input and output field type MUST be the same
+		return nil, fmt.Errorf("has type %s, but %s in %s", inputField.Type, outputField.Type, output)
+	}
+	return f, nil
+}
+
+// isUnboundValue reports whether v could be encrypted before ciphertexts were bound to their field in version 1:
+// scalars stored as strings, nested structs and slices of these
+func isUnboundValue(v *valuePlan, mode transformMode) bool {
+	secureType := v.output
+	if mode == decryptMode {
+		secureType = v.input
+	}
+	switch v.op {
+	case opScalar:
+		return secureType.Kind() == reflect.String
+	case opTransformer:
+		return true
+	case opSlice:
+		return isUnboundValue(v.elem, mode)
+	default:
+		return false
+	}
+}
+
+// containsTransformer reports whether nested structs are transformed as part of the value of v
+func containsTransformer(v *valuePlan) bool {
+	if v == nil {
+		return false
+	}
+	return v.op == opTransformer || containsTransformer(v.elem) || containsTransformer(v.key)
+}
+
+// findOutputField returns the field of output matching the inputField by name.
+// Embedded transformers can also be matched by the type created from their TransformConfig,
+// e.g. an embedded Credentials is stored in an embedded SecureCredentials.
+func findOutputField(inputField reflect.StructField, tag Tag, output reflect.Type, mode transformMode) (reflect.StructField, bool) {
+	if outputField, ok := output.FieldByName(inputField.Name); ok {
+		return outputField, true
+	}
+	if !inputField.Anonymous || !tag.Enabled || !implementsEither(inputField.Type, mode.transformerType()) {
+		return reflect.StructField{}, false
+	}
+
+	expected := transformOutputType(getEmbeddedTransformConfig(reflect.Zero(inputField.Type)), mode)
+	for i := 0; i < output.NumField(); i++ {
+		if output.Field(i).Anonymous && output.Field(i).Type == expected {
+			return output.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// checkIndexPath verifies that a promoted field at index can be set without allocating,
+// which requires that none of the embedded structs on the path is a pointer
+func checkIndexPath(t reflect.Type, index []int) error {
+	for i := 0; i < len(index)-1; i++ {
+		f := t.Field(index[i])
+		if f.Type.Kind() != reflect.Struct {
+			return fmt.Errorf("is promoted through embedded pointer %s", f.Name)
+		}
+		t = f.Type
+	}
+	return nil
+}
+
+func compileValuePlan(input reflect.Type, output reflect.Type, mode transformMode) (*valuePlan, error) {
+	var err error
+
+	v := &valuePlan{
+		input:  input,
+		output: output,
+	}
+
+	plainType, secureType := input, output
+	if mode == decryptMode {
+		plainType, secureType = output, input
+	}
+
+	switch {
+	case input.Kind() == reflect.Pointer:
+		// Checked first, as the method set of a pointer includes the methods of its element type
+		if output.Kind() != reflect.Pointer {
+			return nil, fmt.Errorf("cannot %s pointer %s into %s", mode, input, output)
+		}
+		v.op = opPointer
+		if v.elem, err = compileValuePlan(input.Elem(), output.Elem(), mode); err != nil {
+			return nil, err
+		}
+	case plainType.Kind() == reflect.Interface:
+		// The concrete type is looked up in the registry, and encrypted together with the value
+		if secureType.Kind() != reflect.String && !isByteSlice(secureType) {
+			return nil, fmt.Errorf("cannot %s %s into %s: encrypted interfaces must be stored as string or []byte", mode, input, output)
+		}
+		v.op = opInterface
+	case implementsEither(input, mode.transformerType()):
+		v.op = opTransformer
+		// Nested plans are resolved when they are used, which allows recursive types
+		c := getEmbeddedTransformConfig(reflect.Zero(input))
+		if expected := transformOutputType(c, mode); expected != output {
+			return nil, fmt.Errorf("transform config of %s declares %v, but field has type %s", input, expected, output)
+		}
+	case isMarshaler(plainType):
+		// Values like time.Time and net.IP are serialized using their marshaler and encrypted as a single value
+		if secureType.Kind() != reflect.String && !isByteSlice(secureType) {
+			return nil, fmt.Errorf("cannot %s %s into %s: encrypted marshalers must be stored as string or []byte", mode, input, output)
+		}
+		v.op = opMarshaler
+		v.text = usesTextMarshaler(plainType)
+	case isByteSlice(plainType):
+		// Byte slices are encrypted as a single value, instead of byte by byte
+		if secureType.Kind() != reflect.String && !isByteSlice(secureType) {
+			return nil, fmt.Errorf("cannot %s %s into %s: encrypted byte slices must be stored as string or []byte", mode, input, output)
+		}
+		v.op = opBytes
+	case isByteSlice(secureType) && isScalarKind(plainType.Kind()):
+		// Checked before slices, as the ciphertext of a scalar value can be stored as raw bytes
+		v.op = opScalar
+	case input.Kind() == reflect.Slice:
+		if output.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("cannot %s slice %s into %s", mode, input, output)
+		}
+		v.op = opSlice
+		if v.elem, err = compileValuePlan(input.Elem(), output.Elem(), mode); err != nil {
+			return nil, err
+		}
+	case input.Kind() == reflect.Array:
+		if output.Kind() != reflect.Array || output.Len() != input.Len() {
+			return nil, fmt.Errorf("cannot %s array %s into %s: output must be an array of length %d", mode, input, output, input.Len())
+		}
+		v.op = opArray
+		if v.elem, err = compileValuePlan(input.Elem(), output.Elem(), mode); err != nil {
+			return nil, err
+		}
+	case input.Kind() == reflect.Map:
+		if output.Kind() != reflect.Map {
+			return nil, fmt.Errorf("cannot %s map %s into %s", mode, input, output)
+		}
+		v.op = opMap
+		// Keys are only encrypted when the key type differs between the plain and the secure type
+		if input.Key() != output.Key() {
+			if v.key, err = compileValuePlan(input.Key(), output.Key(), mode); err != nil {
+				return nil, err
+			}
+			if v.key.op != opScalar {
+				return nil, fmt.Errorf("cannot %s map key %s into %s: only scalar keys can be encrypted", mode, input.Key(), output.Key())
+			}
+		}
+		if v.elem, err = compileValuePlan(input.Elem(), output.Elem(), mode); err != nil {
+			return nil, err
+		}
+	default:
+		v.op = opScalar
+		if !isScalarKind(plainType.Kind()) {
+			return nil, fmt.Errorf("cannot %s %s into %s: %w %s", mode, input, output, ErrUnsupportedKind, plainType.Kind())
+		}
+		// Scalar values are stored as encoded strings or raw bytes on the secure type
+		if secureType.Kind() != reflect.String && !isByteSlice(secureType) {
+			return nil, fmt.Errorf("cannot %s %s into %s: encrypted values must be stored as string or []byte", mode, input, output)
+		}
+	}
+	return v, nil
+}
+
+// isMarshaler reports whether t can be serialized and restored using either
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, or encoding.TextMarshaler and encoding.TextUnmarshaler
+func isMarshaler(t reflect.Type) bool {
+	return (implementsEither(t, binaryMarshalerType) && implementsEither(t, binaryUnmarshalerType)) ||
+		(implementsEither(t, textMarshalerType) && implementsEither(t, textUnmarshalerType))
+}
+
+// usesTextMarshaler reports whether a marshaler of type t is serialized using encoding.TextMarshaler,
+// encoding.BinaryMarshaler is preferred when t implements both
+func usesTextMarshaler(t reflect.Type) bool {
+	return !implementsEither(t, binaryMarshalerType) || !implementsEither(t, binaryUnmarshalerType)
+}
+
+// implementsEither reports whether t or *t implements the interface u
+func implementsEither(t reflect.Type, u reflect.Type) bool {
+	return t.Implements(u) || reflect.PointerTo(t).Implements(u)
+}
+
+// isScalarKind reports whether values of kind k can be serialized by convertValueToBytes
+func isScalarKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	default:
+		return false
+	}
+}
+
+// isByteSlice reports whether t is []byte or a named type with []byte as underlying type
+func isByteSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// structValue returns the struct in r, which can be a struct or a non-nil pointer to a struct
+func structValue(r any) (reflect.Value, error) {
+	v := reflect.ValueOf(r)
+	if !v.IsValid() {
+		return reflect.Value{}, fmt.Errorf("cannot transform nil")
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return reflect.Value{}, fmt.Errorf("cannot transform nil pointer of type %s", v.Type())
+		}
+		v = v.Elem()
+	}
+	return v, nil
+}
+
+// structType returns the element type of t if t is a pointer, and whether t is a pointer
+func structType(t reflect.Type) (reflect.Type, bool) {
+	if t != nil && t.Kind() == reflect.Pointer {
+		return t.Elem(), true
+	}
+	return t, false
+}
+
+// transformOutputType returns the type which is created from the TransformConfig in the transformMode
+// Nested structs are always stored as values, so pointer types in the TransformConfig are dereferenced.
+func transformOutputType(c TransformConfig, mode transformMode) reflect.Type {
+	t := reflect.TypeOf(c.Decrypted)
+	if mode == encryptMode {
+		t = reflect.TypeOf(c.Encrypted)
+	}
+	t, _ = structType(t)
+	return t
+}
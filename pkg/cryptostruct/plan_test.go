@@ -0,0 +1,284 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testMissingFieldData struct {
+	Name    string `secure:"true"`
+	Missing string `secure:"true"`
+}
+
+type testTypeMismatchData struct {
+	Name  string `secure:"true"`
+	Plain int    `secure:"false"`
+}
+
+type testMapKeyData struct {
+	Keys map[testSecondEmbeddedData]string `secure:"true"`
+}
+
+type testSecureMapKeyData struct {
+	Keys         map[testSecureSecondEmbeddedData]string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+type testArrayLengthData struct {
+	Numbers [3]int `secure:"true"`
+}
+
+type testSecureArrayLengthData struct {
+	Numbers      [4]string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+type testEmbeddedPointerData struct {
+	*EmbeddedCredentials
+}
+
+type testInvalidTagData struct {
+	Name string `secure:"yes"`
+}
+
+type testTagMismatchData struct {
+	Name string `secure:"true,omitempty"`
+}
+
+type testNestedTagOptionsData struct {
+	Details []testEmbeddedData `secure:"true,key=pii"`
+}
+
+type testSecureNestedTagOptionsData struct {
+	Details      []testSecureEmbeddedData `secure:"true,key=pii"`
+	CryptoParams CryptoParams
+}
+
+type testNoCryptoParamsData struct {
+	Name string `secure:"true"`
+}
+
+func TestGetPlan_Cached(t *testing.T) {
+	input := reflect.TypeOf(testData{})
+	output := reflect.TypeOf(testSecureData{})
+
+	first, err := getPlan(input, output, encryptMode)
+	if err != nil {
+		t.Fatalf("getPlan() error = %v", err)
+	}
+	second, err := getPlan(input, output, encryptMode)
+	if err != nil {
+		t.Fatalf("getPlan() error = %v", err)
+	}
+	if first != second {
+		t.Error("getPlan() did not return the cached plan")
+	}
+
+	decryptPlan, err := getPlan(output, input, decryptMode)
+	if err != nil {
+		t.Fatalf("getPlan() error = %v", err)
+	}
+	if decryptPlan == first {
+		t.Error("getPlan() returned the same plan for encryptMode and decryptMode")
+	}
+}
+
+func TestCompilePlan(t *testing.T) {
+	p, err := compilePlan(reflect.TypeOf(testData{}), reflect.TypeOf(testSecureData{}), encryptMode)
+	if err != nil {
+		t.Fatalf("compilePlan() error = %v", err)
+	}
+
+	expected := map[string]valueOp{
+		"Name":         opScalar,
+		"Count":        opScalar,
+		"Details":      opTransformer,
+		"SliceDetails": opSlice,
+		"NumberSlice":  opSlice,
+	}
+	for _, field := range p.fields {
+		op, ok := expected[field.name]
+		if !ok {
+			continue
+		}
+		if field.value == nil {
+			t.Errorf("field %s is not transformed", field.name)
+			continue
+		}
+		if field.value.op != op {
+			t.Errorf("field %s has op %d, want %d", field.name, field.value.op, op)
+		}
+	}
+	if p.cryptoParamsIndex != reflect.TypeOf(testSecureData{}).NumField()-1 {
+		t.Errorf("cryptoParamsIndex = %d", p.cryptoParamsIndex)
+	}
+}
+
+func TestCompilePlan_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  any
+		output any
+	}{
+		{name: "missing field", input: testMissingFieldData{}, output: testSecureData{}},
+		{name: "type mismatch", input: testTypeMismatchData{}, output: testSecureData{}},
+		{name: "no CryptoParams", input: testNoCryptoParamsData{}, output: testNoCryptoParamsData{}},
+		{name: "not a struct", input: "", output: testSecureData{}},
+		{name: "struct map key", input: testMapKeyData{}, output: testSecureMapKeyData{}},
+		{name: "array length", input: testArrayLengthData{}, output: testSecureArrayLengthData{}},
+		{name: "untagged embedded pointer", input: testEmbeddedPointerData{}, output: testSecureData{}},
+		{name: "invalid tag", input: testInvalidTagData{}, output: testSecureData{}},
+		{name: "tag mismatch", input: testTagMismatchData{}, output: testSecureData{}},
+		{name: "options on nested struct", input: testNestedTagOptionsData{}, output: testSecureNestedTagOptionsData{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compilePlan(reflect.TypeOf(tt.input), reflect.TypeOf(tt.output), encryptMode); err == nil {
+				t.Error("compilePlan() expected error")
+			}
+		})
+	}
+}
+
+func TestCompilePlan_DecryptArrayLength(t *testing.T) {
+	if _, err := compilePlan(reflect.TypeOf(testSecureArrayLengthData{}), reflect.TypeOf(testArrayLengthData{}), decryptMode); err == nil {
+		t.Error("compilePlan() expected error for array length mismatch")
+	}
+}
+
+func BenchmarkGetPlan(b *testing.B) {
+	input := reflect.TypeOf(testData{})
+	output := reflect.TypeOf(testSecureData{})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := getPlan(input, output, encryptMode); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := compilePlan(input, output, encryptMode); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// referenceDecrypter decrypts the way Decrypter did before plans were compiled: tags, output fields and
+// transformer implementations are looked up using reflection for every struct and every slice element.
+// It only supports the scalars, slices and nested structs of testData, and serves as the baseline for the plans.
+type referenceDecrypter struct {
+	Decrypter
+}
+
+func (t referenceDecrypter) transform(inputValue reflect.Value, outputType reflect.Type, path string) (reflect.Value, error) {
+	params := inputValue.Interface().(DecryptTransformer).GetCryptoParams()
+	key, err := t.keys.decryptionKey(params)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	c, err := newCryptoContext(params, key, inputValue.Type())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	output := reflect.New(outputType).Elem()
+	for i := 0; i < inputValue.NumField(); i++ {
+		field := inputValue.Type().Field(i)
+		if field.Type == cryptoParamsType {
+			continue
+		}
+		tag, _, err := getTag(field)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		outputField := output.FieldByName(field.Name)
+		if !tag.Enabled {
+			outputField.Set(inputValue.Field(i))
+			continue
+		}
+
+		var value reflect.Value
+		if field.Type.Kind() == reflect.Slice {
+			value = reflect.MakeSlice(outputField.Type(), 0, 0)
+			for j := 0; j < inputValue.Field(i).Len(); j++ {
+				elem, err := t.decryptValue(inputValue.Field(i).Index(j), outputField.Type().Elem(), c, indexPath(fieldPath(path, field.Name), j))
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				value = reflect.Append(value, elem)
+			}
+		} else if value, err = t.decryptValue(inputValue.Field(i), outputField.Type(), c, fieldPath(path, field.Name)); err != nil {
+			return reflect.Value{}, err
+		}
+		outputField.Set(value)
+	}
+	return output, nil
+}
+
+func (t referenceDecrypter) decryptValue(v reflect.Value, outputType reflect.Type, c *cryptoContext, path string) (reflect.Value, error) {
+	if v.Type().Implements(decryptTransformerType) {
+		return t.transform(v, reflect.TypeOf(getEmbeddedTransformConfig(v).Decrypted), path)
+	}
+	return t.decryptFields(v, outputType, c, path)
+}
+
+func BenchmarkDecrypterTransform(b *testing.B) {
+	encrypted, err := NewEncrypter(testMasterKey, newTestCryptoParams(b), testData{}.GetTransformConfig()).Transform(newTestData())
+	if err != nil {
+		b.Fatal(err)
+	}
+	decrypter := NewDecrypter(testMasterKey, testData{}.GetTransformConfig())
+	reference := referenceDecrypter{decrypter}
+
+	// The baseline must produce the same output, so both paths do the same work
+	expected, err := reference.transform(reflect.ValueOf(encrypted), reflect.TypeOf(testData{}), "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected.Interface(), newTestData()) {
+		b.Fatalf("reference output = %+v, want %+v", expected.Interface(), newTestData())
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err = decrypter.Transform(encrypted.(testSecureData)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			plans.Clear()
+			if _, err = decrypter.Transform(encrypted.(testSecureData)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reflection", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err = reference.transform(reflect.ValueOf(encrypted), reflect.TypeOf(testData{}), ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
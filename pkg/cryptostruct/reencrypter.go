@@ -0,0 +1,55 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"fmt"
+)
+
+// Reencrypt decrypts data using the master key from KeySource from and encrypts it again using KeySource to.
+// The cipher suite and settings for the new ciphertext are taken from p, but a new nonce is generated for the struct
+// and every nested struct, so p can be the CryptoParams of data itself when only the master key is rotated.
+// The options are applied to both decryption and encryption.
+func Reencrypt(data DecryptTransformer, from KeySource, to KeySource, p CryptoParams, opts ...Option) (any, error) {
+	var (
+		err       error
+		decrypted any
+	)
+
+	c := data.GetTransformConfig()
+	if decrypted, err = NewDecrypterWithKeySource(from, c, opts...).Transform(data); err != nil {
+		return nil, fmt.Errorf("could not decrypt data for reencryption: %w", err)
+	}
+
+	if p, err = p.renew(); err != nil {
+		return nil, err
+	}
+	return NewEncrypterWithKeySource(to, p, c, opts...).Transform(decrypted)
+}
+
+// ReencryptAs is the typed version of Reencrypt, returning a value of secure type S.
+func ReencryptAs[S DecryptTransformer](data S, from KeySource, to KeySource, p CryptoParams, opts ...Option) (S, error) {
+	var (
+		err    error
+		output any
+		result S
+	)
+	if output, err = Reencrypt(data, from, to, p, opts...); err != nil {
+		return result, err
+	}
+	return convertOutput[S](output), nil
+}
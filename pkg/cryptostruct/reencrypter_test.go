@@ -0,0 +1,82 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReencrypt(t *testing.T) {
+	input := newTestData()
+	oldKey := NewMasterKey(testMasterKey)
+	newKeys := newTestKeyring(t, "v2")
+	if err := newKeys.SetActive("v2"); err != nil {
+		t.Fatalf("SetActive() error = %v", err)
+	}
+
+	encrypted, err := EncryptAs[testData, testSecureData]("masterKey", newTestCryptoParams(t), input)
+	if err != nil {
+		t.Fatalf("EncryptAs() error = %v", err)
+	}
+
+	newParams, err := NewCryptoParams("CHACHA20_POLY1305")
+	if err != nil {
+		t.Fatalf("NewCryptoParams() error = %v", err)
+	}
+	reencrypted, err := ReencryptAs(encrypted, oldKey, newKeys, newParams)
+	if err != nil {
+		t.Fatalf("ReencryptAs() error = %v", err)
+	}
+
+	// Every level uses a fresh nonce and the new parameters
+	pairs := [][2]CryptoParams{
+		{encrypted.CryptoParams, reencrypted.CryptoParams},
+		{encrypted.Details.CryptoParams, reencrypted.Details.CryptoParams},
+		{encrypted.SliceDetails[0].CryptoParams, reencrypted.SliceDetails[0].CryptoParams},
+		{encrypted.SliceDetails[1].CryptoParams, reencrypted.SliceDetails[1].CryptoParams},
+	}
+	for _, pair := range pairs {
+		if pair[1].Nonce == pair[0].Nonce || pair[1].Nonce == newParams.Nonce {
+			t.Errorf("nonce %s was reused", pair[1].Nonce)
+		}
+		if pair[1].CipherSuite != "CHACHA20_POLY1305" || pair[1].KeyID != "v2" {
+			t.Errorf("CryptoParams = %+v, want CHACHA20_POLY1305 with key v2", pair[1])
+		}
+	}
+
+	if _, err = NewDecrypterWithKeySource(oldKey, input.GetTransformConfig()).Transform(reencrypted); err == nil {
+		t.Error("Decrypter.Transform() expected error using the old key")
+	}
+	decrypted, err := NewDecrypterWithKeySource(newKeys, input.GetTransformConfig()).Transform(reencrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Decrypter.Transform() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestReencrypt_WrongKey(t *testing.T) {
+	encrypted, err := EncryptAs[testData, testSecureData]("masterKey", newTestCryptoParams(t), newTestData())
+	if err != nil {
+		t.Fatalf("EncryptAs() error = %v", err)
+	}
+	if _, err = Reencrypt(encrypted, NewMasterKey("00"), NewMasterKey(testMasterKey), encrypted.CryptoParams); err == nil {
+		t.Error("Reencrypt() expected error for wrong key")
+	}
+}
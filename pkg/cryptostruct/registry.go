@@ -0,0 +1,187 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Registered types by name and names by type, used to encrypt fields with an interface type
+var registry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}{
+	types: make(map[string]reflect.Type),
+	names: make(map[reflect.Type]string),
+}
+
+// RegisterType registers the concrete type of v under name, so values of that type can be encrypted in fields
+// declared as any or as another interface type. The name is encrypted together with the value and is used to restore
+// a value of the same type when decrypting, so it must not change once data is encrypted.
+// Types must be scalars, byte slices, types implementing encoding.BinaryMarshaler or encoding.TextMarshaler, structs,
+// or pointers to any of these. Structs, including EncryptTransformer types, are serialized using encoding/json as a
+// whole and encrypted as a single value, so all their fields must be exported. Interface fields of a registered struct
+// are restored as the types chosen by encoding/json.
+// Registering the same type under the same name again has no effect.
+func RegisterType(name string, v any) error {
+	if name == "" {
+		return fmt.Errorf("type name must not be empty")
+	}
+	if v == nil {
+		return fmt.Errorf("cannot register type of nil value as %s", name)
+	}
+
+	t := reflect.TypeOf(v)
+	if !isRegistrable(t) {
+		return fmt.Errorf("cannot register type %s as %s: %w %s, only scalars, byte slices, marshalers and structs can be registered", t, name, ErrUnsupportedKind, t.Kind())
+	}
+	if field, ok := unexportedField(t); ok {
+		return fmt.Errorf("cannot register type %s as %s: field %s is not exported", t, name, field)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if existing, ok := registry.types[name]; ok && existing != t {
+		return fmt.Errorf("cannot register type %s as %s: name is already registered for type %s", t, name, existing)
+	}
+	if existing, ok := registry.names[t]; ok && existing != name {
+		return fmt.Errorf("cannot register type %s as %s: type is already registered as %s", t, name, existing)
+	}
+	registry.types[name] = t
+	registry.names[t] = name
+	return nil
+}
+
+func isRegistrable(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return isMarshaler(t) || isByteSlice(t) || isScalarKind(t.Kind()) || t.Kind() == reflect.Struct
+}
+
+// unexportedField returns the name of the first unexported field of a struct serialized using encoding/json
+func unexportedField(t reflect.Type) (string, bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || isMarshaler(t) {
+		return "", false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() && !t.Field(i).Anonymous {
+			return t.Field(i).Name, true
+		}
+	}
+	return "", false
+}
+
+// marshalRegistered serializes the name of the registered type of v, followed by the value of v
+func marshalRegistered(v reflect.Value) ([]byte, error) {
+	registry.RLock()
+	name, ok := registry.names[v.Type()]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("type %s is not registered, use RegisterType", v.Type())
+	}
+
+	data, err := marshalRegisteredValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal %s: %w", v.Type(), err)
+	}
+
+	output := binary.AppendUvarint(make([]byte, 0, len(name)+len(data)+binary.MaxVarintLen64), uint64(len(name)))
+	output = append(output, name...)
+	return append(output, data...), nil
+}
+
+func marshalRegisteredValue(v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, fmt.Errorf("nil pointer")
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case isMarshaler(v.Type()):
+		return marshalValue(v, usesTextMarshaler(v.Type()))
+	case isByteSlice(v.Type()):
+		return v.Bytes(), nil
+	case v.Kind() == reflect.Struct:
+		return json.Marshal(v.Interface())
+	default:
+		return convertValueToBytes(v)
+	}
+}
+
+// unmarshalRegistered restores a value serialized by marshalRegistered.
+// Scalar values are raw bytes if rawPlaintext is true, they were hex encoded before version 2 of CryptoParams.
+func unmarshalRegistered(data []byte, rawPlaintext bool) (reflect.Value, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return reflect.Value{}, fmt.Errorf("invalid type name")
+	}
+	name := string(data[n : n+int(length)])
+
+	registry.RLock()
+	t, ok := registry.types[name]
+	registry.RUnlock()
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("type name %s is not registered, use RegisterType", name)
+	}
+
+	output, err := unmarshalRegisteredValue(data[n+int(length):], t, rawPlaintext)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("could not unmarshal %s: %w", t, err)
+	}
+	return output, nil
+}
+
+func unmarshalRegisteredValue(data []byte, t reflect.Type, rawPlaintext bool) (reflect.Value, error) {
+	if t.Kind() == reflect.Pointer {
+		elem, err := unmarshalRegisteredValue(data, t.Elem(), rawPlaintext)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		output := reflect.New(t.Elem())
+		output.Elem().Set(elem)
+		return output, nil
+	}
+
+	switch {
+	case isMarshaler(t):
+		return unmarshalValue(data, t, usesTextMarshaler(t))
+	case isByteSlice(t):
+		return reflect.ValueOf(bytes.Clone(data)).Convert(t), nil
+	case t.Kind() == reflect.Struct:
+		output := reflect.New(t)
+		if err := json.Unmarshal(data, output.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return output.Elem(), nil
+	case rawPlaintext:
+		return convertBytesToValue(data, t)
+	default:
+		return convertHexStringToValue(string(data), t)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testRegisteredInt int
+
+type testUnregisteredInt int
+
+type testRegisteredStruct struct {
+	Host  string
+	Port  int
+	Tags  []string
+	Inner *testRegisteredStruct
+}
+
+type testUnexportedStruct struct {
+	Host string
+	port int
+}
+
+type testInterfaceData struct {
+	Value    any          `secure:"true"`
+	Values   []any        `secure:"true"`
+	Stringer fmt.Stringer `secure:"true"`
+	Missing  any          `secure:"true"`
+}
+
+func (d testInterfaceData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testInterfaceData{},
+		Encrypted: testSecureInterfaceData{},
+	}
+}
+
+type testSecureInterfaceData struct {
+	Value        string   `secure:"true"`
+	Values       []string `secure:"true"`
+	Stringer     []byte   `secure:"true"`
+	Missing      string   `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureInterfaceData) GetTransformConfig() TransformConfig {
+	return testInterfaceData{}.GetTransformConfig()
+}
+
+func (d testSecureInterfaceData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func registerTestTypes(t *testing.T) {
+	t.Helper()
+	types := map[string]any{
+		"test.string":   "",
+		"test.int":      testRegisteredInt(0),
+		"test.time":     time.Time{},
+		"test.duration": time.Duration(0),
+		"test.bigint":   &big.Int{},
+		"test.bytes":    []byte{},
+		"test.struct":   testRegisteredStruct{},
+		"test.embedded": testEmbeddedData{},
+	}
+	for name, v := range types {
+		if err := RegisterType(name, v); err != nil {
+			t.Fatalf("RegisterType(%s) error = %v", name, err)
+		}
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	registerTestTypes(t)
+
+	// Registering the same type under the same name again has no effect
+	if err := RegisterType("test.int", testRegisteredInt(1)); err != nil {
+		t.Errorf("RegisterType() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		typeName string
+		value    any
+	}{
+		{name: "empty name", typeName: "", value: 0},
+		{name: "nil value", typeName: "test.nil", value: nil},
+		{name: "struct with unexported field", typeName: "test.unexported", value: testUnexportedStruct{}},
+		{name: "slice", typeName: "test.slice", value: []int{}},
+		{name: "name registered for other type", typeName: "test.int", value: int64(0)},
+		{name: "type registered under other name", typeName: "test.other", value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterType(tt.typeName, tt.value); err == nil {
+				t.Error("RegisterType() expected error")
+			}
+		})
+	}
+}
+
+func TestDecrypter_Transform_Interface(t *testing.T) {
+	registerTestTypes(t)
+
+	input := testInterfaceData{
+		Value: time.Date(2025, 1, 31, 23, 59, 59, 0, time.UTC),
+		Values: []any{
+			"insecuredata",
+			testRegisteredInt(42),
+			big.NewInt(1234567890),
+			[]byte("secret"),
+			nil,
+			testRegisteredStruct{Host: "localhost", Port: 443, Tags: []string{"a"}, Inner: &testRegisteredStruct{Host: "inner"}},
+			testEmbeddedData{FirstName: "First", LastName: "Last", Details: testSecondEmbeddedData{Age: 42}},
+		},
+		Stringer: 90 * time.Second,
+	}
+
+	output, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+	if err != nil {
+		t.Fatalf("Encrypter.Transform() error = %v", err)
+	}
+	encrypted := output.(testSecureInterfaceData)
+	if encrypted.Missing != "" || encrypted.Values[4] != "" {
+		t.Errorf("nil interfaces were not preserved: %+v", encrypted)
+	}
+
+	decrypted, err := NewDecrypter(testMasterKey, input.GetTransformConfig()).Transform(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestEncrypter_Transform_UnregisteredInterface(t *testing.T) {
+	input := testInterfaceData{Value: testUnregisteredInt(1)}
+	if _, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input); err == nil {
+		t.Error("Transform() expected error for unregistered type")
+	}
+}
+
+func TestMarshalRegistered_RawScalars(t *testing.T) {
+	registerTestTypes(t)
+
+	data, err := marshalRegistered(reflect.ValueOf("secret"))
+	if err != nil {
+		t.Fatalf("marshalRegistered() error = %v", err)
+	}
+	// The name of the type is followed by the value as raw bytes, not hex encoded
+	if !bytes.HasSuffix(data, []byte("test.string"+"secret")) {
+		t.Errorf("marshalRegistered() = %q, want raw value", data)
+	}
+	if value, err := unmarshalRegistered(data, true); err != nil || value.Interface() != "secret" {
+		t.Errorf("unmarshalRegistered() = %v, %v", value, err)
+	}
+
+	// Scalar values were hex encoded before version 2
+	legacy := append(data[:len(data)-len("secret"):len(data)-len("secret")], hex.EncodeToString([]byte("secret"))...)
+	if value, err := unmarshalRegistered(legacy, false); err != nil || value.Interface() != "secret" {
+		t.Errorf("unmarshalRegistered() = %v, %v", value, err)
+	}
+}
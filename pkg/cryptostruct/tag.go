@@ -17,42 +17,95 @@
 package cryptostruct
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 )
 
+// Encodings of the ciphertext of fields with a string type on the secure type
+const (
+	EncodingHex       = "hex"
+	EncodingBase64    = "base64"
+	EncodingBase64URL = "base64url"
+)
+
+// Tag holds the parsed secure tag of a field, e.g. `secure:"true,key=pii,encoding=base64,omitempty,deterministic"`.
+// The first value enables or disables encryption of the field and must be "true" or "false".
+// The options which follow are only allowed when encryption is enabled:
+//   - key=<name>: encrypt the field using a separate key, derived from the key of the struct for name
+//   - encoding=<encoding>: encode the ciphertext using EncodingHex, EncodingBase64 or EncodingBase64URL, instead of
+//     the Encoding of the CryptoParams
+//   - omitempty: do not encrypt the zero value, the field is left empty on the secure type. An empty field decrypts to
+//     the zero value, so a ciphertext which is removed from storage is only detected using WithIntegrity
+//   - deterministic: equal values in the same field of the same secure type produce equal ciphertexts when they are
+//     encrypted using the same master key and cipher suite, also in other structs and nested structs, which allows
+//     comparing encrypted values but reveals which values are equal. The key is derived from the master key instead
+//     of the key of the struct, so with a KeyProvider, which uses a new data key for every struct, ciphertexts are
+//     only equal within a struct. The ciphertexts are bound to the field, but not to their slice index or map key.
 type Tag struct {
-	Enabled bool
+	Enabled       bool
+	Key           string
+	Encoding      string
+	OmitEmpty     bool
+	Deterministic bool
 }
 
-func getTags(r any) (map[string]Tag, error) {
-	var err error
+// getTag returns the parsed secure tag of field f, and whether the tag is set
+func getTag(f reflect.StructField) (Tag, bool, error) {
+	tag, ok := f.Tag.Lookup("secure")
+	if !ok {
+		return Tag{}, false, nil
+	}
+	t, err := parseTag(tag)
+	return t, true, err
+}
 
-	t := reflect.TypeOf(r)
+func parseTag(t string) (Tag, error) {
+	var tag Tag
 
-	// Create a map with capacity of the number of fields in T
-	var m = make(map[string]Tag, t.NumField())
+	values := strings.Split(t, ",")
+	switch values[0] {
+	case "true":
+		tag.Enabled = true
+	case "false":
+		tag.Enabled = false
+	default:
+		return Tag{}, fmt.Errorf("invalid secure tag %q: must start with \"true\" or \"false\"", t)
+	}
+	if !tag.Enabled && len(values) > 1 {
+		return Tag{}, fmt.Errorf("invalid secure tag %q: options require \"true\"", t)
+	}
 
-	for i := 0; i < t.NumField(); i++ {
-		fieldName := t.Field(i).Name
+	seen := make(map[string]bool)
+	for _, option := range values[1:] {
+		name, value, hasValue := strings.Cut(option, "=")
+		if seen[name] {
+			return Tag{}, fmt.Errorf("invalid secure tag %q: duplicate option %s", t, name)
+		}
+		seen[name] = true
 
-		// Check if secure tag is set on field
-		tag, ok := t.Field(i).Tag.Lookup("secure")
-		if !ok {
-			continue
+		switch {
+		case name == "key" && hasValue && value != "":
+			tag.Key = value
+		case name == "encoding" && hasValue:
+			switch value {
+			case EncodingHex, EncodingBase64, EncodingBase64URL:
+				tag.Encoding = value
+			default:
+				return Tag{}, fmt.Errorf("invalid secure tag %q: unknown encoding %q", t, value)
+			}
+		case name == "omitempty" && !hasValue:
+			tag.OmitEmpty = true
+		case name == "deterministic" && !hasValue:
+			tag.Deterministic = true
+		default:
+			return Tag{}, fmt.Errorf("invalid secure tag %q: unknown option %q", t, option)
 		}
-		m[fieldName] = parseTag(tag)
 	}
-
-	return m, err
+	return tag, nil
 }
 
-func parseTag(t string) Tag {
-	var tag Tag
-	switch t {
-	case "true":
-		tag = Tag{Enabled: true}
-	default:
-		tag = Tag{Enabled: false}
-	}
-	return tag
+// hasCryptoOptions reports whether the options in the tag change how the field is encrypted
+func (t Tag) hasCryptoOptions() bool {
+	return t.Key != "" || t.Encoding != "" || t.Deterministic
 }
@@ -15,3 +15,46 @@
  */
 
 package cryptostruct
+
+import (
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		want    Tag
+		wantErr bool
+	}{
+		{tag: "true", want: Tag{Enabled: true}},
+		{tag: "false", want: Tag{}},
+		{
+			tag:  "true,key=pii,encoding=base64,omitempty,deterministic",
+			want: Tag{Enabled: true, Key: "pii", Encoding: EncodingBase64, OmitEmpty: true, Deterministic: true},
+		},
+		{tag: "true,encoding=base64url", want: Tag{Enabled: true, Encoding: EncodingBase64URL}},
+		{tag: "", wantErr: true},
+		{tag: "True", wantErr: true},
+		{tag: "yes", wantErr: true},
+		{tag: "false,omitempty", wantErr: true},
+		{tag: "true,omitEmpty", wantErr: true},
+		{tag: "true,key", wantErr: true},
+		{tag: "true,key=", wantErr: true},
+		{tag: "true,encoding=base32", wantErr: true},
+		{tag: "true,omitempty=true", wantErr: true},
+		{tag: "true,omitempty,omitempty", wantErr: true},
+		{tag: "true,", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			got, err := parseTag(tt.tag)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTag() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTag() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+var testMasterKey = hex.EncodeToString([]byte("masterKey"))
+
+type testSecondEmbeddedData struct {
+	Age int `secure:"false"`
+}
+
+func (d testSecondEmbeddedData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testSecondEmbeddedData{},
+		Encrypted: testSecureSecondEmbeddedData{},
+	}
+}
+
+type testSecureSecondEmbeddedData struct {
+	Age          int `secure:"false"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureSecondEmbeddedData) GetTransformConfig() TransformConfig {
+	return testSecondEmbeddedData{}.GetTransformConfig()
+}
+
+func (d testSecureSecondEmbeddedData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testEmbeddedData struct {
+	FirstName string                 `secure:"true"`
+	LastName  string                 `secure:"true"`
+	Details   testSecondEmbeddedData `secure:"false"`
+}
+
+func (d testEmbeddedData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testEmbeddedData{},
+		Encrypted: testSecureEmbeddedData{},
+	}
+}
+
+type testSecureEmbeddedData struct {
+	FirstName    string                 `secure:"true"`
+	LastName     string                 `secure:"true"`
+	Details      testSecondEmbeddedData `secure:"false"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureEmbeddedData) GetTransformConfig() TransformConfig {
+	return testEmbeddedData{}.GetTransformConfig()
+}
+
+func (d testSecureEmbeddedData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+type testData struct {
+	Name         string             `secure:"true"`
+	Title        string             `secure:"true"`
+	Count        int                `secure:"true"`
+	Enabled      bool               `secure:"true"`
+	Ratio        float64            `secure:"true"`
+	Plain        string             `secure:"false"`
+	Details      testEmbeddedData   `secure:"true"`
+	SliceDetails []testEmbeddedData `secure:"true"`
+	NumberSlice  []int              `secure:"true"`
+}
+
+func (d testData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testData{},
+		Encrypted: testSecureData{},
+	}
+}
+
+type testSecureData struct {
+	Name         string                   `secure:"true"`
+	Title        string                   `secure:"true"`
+	Count        string                   `secure:"true"`
+	Enabled      string                   `secure:"true"`
+	Ratio        string                   `secure:"true"`
+	Plain        string                   `secure:"false"`
+	Details      testSecureEmbeddedData   `secure:"true"`
+	SliceDetails []testSecureEmbeddedData `secure:"true"`
+	NumberSlice  []string                 `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func (d testSecureData) GetTransformConfig() TransformConfig {
+	return testData{}.GetTransformConfig()
+}
+
+func (d testSecureData) GetCryptoParams() CryptoParams {
+	return d.CryptoParams
+}
+
+func newTestData() testData {
+	return testData{
+		Name:    "insecuredata",
+		Title:   "insecuretitle",
+		Count:   130586,
+		Enabled: true,
+		Ratio:   0.75,
+		Plain:   "plain",
+		Details: testEmbeddedData{
+			FirstName: "First",
+			LastName:  "Last",
+		},
+		SliceDetails: []testEmbeddedData{
+			{
+				FirstName: "Slice1-First",
+				LastName:  "Slice1-Last",
+				Details:   testSecondEmbeddedData{Age: 10},
+			},
+			{
+				FirstName: "Slice2-First",
+				LastName:  "Slice2-Last",
+				Details:   testSecondEmbeddedData{Age: 120},
+			},
+		},
+		NumberSlice: []int{1, 2, 3, 4, 5},
+	}
+}
+
+func newTestCryptoParams(t testing.TB) CryptoParams {
+	t.Helper()
+	p, err := NewCryptoParams("AES_256_GCM")
+	if err != nil {
+		t.Fatalf("NewCryptoParams() error = %v", err)
+	}
+	return p
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+)
+
+// EncryptAs encrypts data of plain type P into a value of secure type S using key.
+func EncryptAs[P EncryptTransformer, S DecryptTransformer](key string, cryptoParams CryptoParams, data P) (S, error) {
+	var (
+		err       error
+		encrypter TypedEncrypter[P, S]
+	)
+	if encrypter, err = NewTypedEncrypter[P, S](hex.EncodeToString([]byte(key)), cryptoParams); err != nil {
+		var output S
+		return output, err
+	}
+	return encrypter.Transform(data)
+}
+
+// DecryptAs decrypts data of secure type S into a value of plain type P using key.
+func DecryptAs[S DecryptTransformer, P EncryptTransformer](key string, data S) (P, error) {
+	var (
+		err       error
+		decrypter TypedDecrypter[S, P]
+	)
+	if decrypter, err = NewTypedDecrypter[S, P](hex.EncodeToString([]byte(key))); err != nil {
+		var output P
+		return output, err
+	}
+	return decrypter.Transform(data)
+}
+
+// NewTypedEncrypter returns an Encrypter for plain type P and secure type S.
+// An error is returned if the TransformConfig of P and S does not declare exactly this pair of types.
+func NewTypedEncrypter[P EncryptTransformer, S DecryptTransformer](masterKeyHex string, p CryptoParams, opts ...Option) (TypedEncrypter[P, S], error) {
+	return NewTypedEncrypterWithKeySource[P, S](masterKey(masterKeyHex), p, opts...)
+}
+
+// NewTypedEncrypterWithKeySource returns an Encrypter for plain type P and secure type S, which gets the master key from the KeySource.
+func NewTypedEncrypterWithKeySource[P EncryptTransformer, S DecryptTransformer](k KeySource, p CryptoParams, opts ...Option) (TypedEncrypter[P, S], error) {
+	var (
+		err error
+		c   TransformConfig
+	)
+	if c, err = getTypedTransformConfig[P, S](); err != nil {
+		return TypedEncrypter[P, S]{}, err
+	}
+	return TypedEncrypter[P, S]{
+		encrypter: NewEncrypterWithKeySource(k, p, c, opts...),
+	}, nil
+}
+
+type TypedEncrypter[P EncryptTransformer, S DecryptTransformer] struct {
+	encrypter Encrypter
+}
+
+func (t TypedEncrypter[P, S]) Transform(r P) (S, error) {
+	var (
+		err    error
+		output any
+		result S
+	)
+	if output, err = t.encrypter.Transform(r); err != nil {
+		return result, err
+	}
+	return convertOutput[S](output), nil
+}
+
+// NewTypedDecrypter returns a Decrypter for secure type S and plain type P.
+// An error is returned if the TransformConfig of P and S does not declare exactly this pair of types.
+func NewTypedDecrypter[S DecryptTransformer, P EncryptTransformer](masterKeyHex string, opts ...Option) (TypedDecrypter[S, P], error) {
+	return NewTypedDecrypterWithKeySource[S, P](masterKey(masterKeyHex), opts...)
+}
+
+// NewTypedDecrypterWithKeySource returns a Decrypter for secure type S and plain type P, which gets the master key from the KeySource.
+func NewTypedDecrypterWithKeySource[S DecryptTransformer, P EncryptTransformer](k KeySource, opts ...Option) (TypedDecrypter[S, P], error) {
+	var (
+		err error
+		c   TransformConfig
+	)
+	if c, err = getTypedTransformConfig[P, S](); err != nil {
+		return TypedDecrypter[S, P]{}, err
+	}
+	return TypedDecrypter[S, P]{
+		decrypter: NewDecrypterWithKeySource(k, c, opts...),
+	}, nil
+}
+
+type TypedDecrypter[S DecryptTransformer, P EncryptTransformer] struct {
+	decrypter Decrypter
+}
+
+// Transform decrypts r. When the Decrypter collects errors, the partially decrypted value is returned together with the errors.
+func (t TypedDecrypter[S, P]) Transform(r S) (P, error) {
+	var (
+		err    error
+		output any
+		result P
+	)
+	if output, err = t.decrypter.Transform(r); output == nil {
+		return result, err
+	}
+	return convertOutput[P](output), err
+}
+
+// getTypedTransformConfig returns the TransformConfig for plain type P and secure type S.
+// Both types must declare the same TransformConfig, with P as the decrypted type and S as the encrypted type.
+// P and S can be pointer types, regardless of whether the TransformConfig declares pointers.
+func getTypedTransformConfig[P EncryptTransformer, S DecryptTransformer]() (TransformConfig, error) {
+	plain := newTransformer[P]()
+	secure := newTransformer[S]()
+	plainType, _ := structType(reflect.TypeOf(plain))
+	secureType, _ := structType(reflect.TypeOf(secure))
+
+	plainConfig := plain.GetTransformConfig()
+	if err := checkTransformConfig(plainConfig, plainType, secureType); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid transform config for %s: %w", plainType, err)
+	}
+
+	secureConfig := secure.GetTransformConfig()
+	if err := checkTransformConfig(secureConfig, plainType, secureType); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid transform config for %s: %w", secureType, err)
+	}
+	return plainConfig, nil
+}
+
+func checkTransformConfig(c TransformConfig, plainType reflect.Type, secureType reflect.Type) error {
+	if decryptedType, _ := structType(reflect.TypeOf(c.Decrypted)); decryptedType != plainType {
+		return fmt.Errorf("decrypted type is %v, expected %s", decryptedType, plainType)
+	}
+	if encryptedType, _ := structType(reflect.TypeOf(c.Encrypted)); encryptedType != secureType {
+		return fmt.Errorf("encrypted type is %v, expected %s", encryptedType, secureType)
+	}
+	return nil
+}
+
+// newTransformer returns the zero value of T, or a pointer to the zero value if T is a pointer type,
+// so methods with a value receiver can be called on it
+func newTransformer[T EncryptTransformer]() T {
+	var t T
+	if tt := reflect.TypeOf(&t).Elem(); tt.Kind() == reflect.Pointer {
+		return reflect.New(tt.Elem()).Interface().(T)
+	}
+	return t
+}
+
+// convertOutput returns output as T, taking its address or dereferencing it when only one of both is a pointer
+func convertOutput[T any](output any) T {
+	if result, ok := output.(T); ok {
+		return result
+	}
+
+	v := reflect.ValueOf(output)
+	if v.Kind() == reflect.Pointer {
+		return v.Elem().Interface().(T)
+	}
+	pointer := reflect.New(v.Type())
+	pointer.Elem().Set(v)
+	return pointer.Interface().(T)
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testMismatchedData struct {
+	Name string `secure:"true"`
+}
+
+func (d testMismatchedData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testMismatchedData{},
+		Encrypted: testSecureData{},
+	}
+}
+
+func TestEncryptAs_DecryptAs(t *testing.T) {
+	input := newTestData()
+
+	encrypted, err := EncryptAs[testData, testSecureData]("masterKey", newTestCryptoParams(t), input)
+	if err != nil {
+		t.Fatalf("EncryptAs() error = %v", err)
+	}
+	if encrypted.Name == input.Name {
+		t.Errorf("EncryptAs() Name was not encrypted")
+	}
+
+	decrypted, err := DecryptAs[testSecureData, testData]("masterKey", encrypted)
+	if err != nil {
+		t.Fatalf("DecryptAs() error = %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, input) {
+		t.Errorf("DecryptAs() = %+v, want %+v", decrypted, input)
+	}
+}
+
+func TestNewTypedEncrypter_Pointers(t *testing.T) {
+	input := newTestData()
+
+	// Pointer type parameters are accepted for a TransformConfig declaring values
+	encrypter, err := NewTypedEncrypter[*testData, *testSecureData](testMasterKey, newTestCryptoParams(t))
+	if err != nil {
+		t.Fatalf("NewTypedEncrypter() error = %v", err)
+	}
+	encrypted, err := encrypter.Transform(&input)
+	if err != nil {
+		t.Fatalf("TypedEncrypter.Transform() error = %v", err)
+	}
+
+	decrypter, err := NewTypedDecrypter[*testSecureData, *testData](testMasterKey)
+	if err != nil {
+		t.Fatalf("NewTypedDecrypter() error = %v", err)
+	}
+	decrypted, err := decrypter.Transform(encrypted)
+	if err != nil {
+		t.Fatalf("TypedDecrypter.Transform() error = %v", err)
+	}
+	if !reflect.DeepEqual(*decrypted, input) {
+		t.Errorf("Transform() = %+v, want %+v", *decrypted, input)
+	}
+
+	// Types declaring their methods on pointer receivers are used through pointer type parameters
+	receiverInput := testReceiverData{Name: "insecuredata"}
+	secure, err := EncryptAs[*testReceiverData, *testSecureReceiverData]("masterKey", newTestCryptoParams(t), &receiverInput)
+	if err != nil {
+		t.Fatalf("EncryptAs() error = %v", err)
+	}
+	receiverOutput, err := DecryptAs[*testSecureReceiverData, *testReceiverData]("masterKey", secure)
+	if err != nil {
+		t.Fatalf("DecryptAs() error = %v", err)
+	}
+	if !reflect.DeepEqual(*receiverOutput, receiverInput) {
+		t.Errorf("DecryptAs() = %+v, want %+v", *receiverOutput, receiverInput)
+	}
+}
+
+func TestNewTypedEncrypter_InvalidPair(t *testing.T) {
+	if _, err := NewTypedEncrypter[testEmbeddedData, testSecureData](testMasterKey, newTestCryptoParams(t)); err == nil {
+		t.Error("NewTypedEncrypter() expected error for mismatched plain type")
+	}
+	if _, err := NewTypedEncrypter[testData, testSecureEmbeddedData](testMasterKey, newTestCryptoParams(t)); err == nil {
+		t.Error("NewTypedEncrypter() expected error for mismatched secure type")
+	}
+	if _, err := NewTypedEncrypter[testMismatchedData, testSecureData](testMasterKey, newTestCryptoParams(t)); err == nil {
+		t.Error("NewTypedEncrypter() expected error for inconsistent transform configs")
+	}
+}
+
+func TestNewTypedDecrypter_InvalidPair(t *testing.T) {
+	if _, err := NewTypedDecrypter[testSecureEmbeddedData, testData](testMasterKey); err == nil {
+		t.Error("NewTypedDecrypter() expected error for mismatched pair")
+	}
+}
+
+func TestTypedDecrypter_Transform_CollectErrors(t *testing.T) {
+	encrypted := newTestEncryptedData(t)
+	encrypted.Title = "00"
+
+	expected := newTestData()
+	expected.Title = ""
+
+	decrypter, err := NewTypedDecrypter[testSecureData, testData](testMasterKey, WithCollectErrors())
+	if err != nil {
+		t.Fatalf("NewTypedDecrypter() error = %v", err)
+	}
+	decrypted, err := decrypter.Transform(encrypted)
+	if err == nil {
+		t.Error("Transform() expected error")
+	}
+	if !reflect.DeepEqual(decrypted, expected) {
+		t.Errorf("Transform() = %+v, want %+v", decrypted, expected)
+	}
+
+	pointerDecrypter, err := NewTypedDecrypter[*testSecureData, *testData](testMasterKey, WithCollectErrors())
+	if err != nil {
+		t.Fatalf("NewTypedDecrypter() error = %v", err)
+	}
+	pointer, err := pointerDecrypter.Transform(&encrypted)
+	if err == nil {
+		t.Error("Transform() expected error")
+	}
+	if pointer == nil || !reflect.DeepEqual(*pointer, expected) {
+		t.Errorf("Transform() = %+v, want %+v", pointer, expected)
+	}
+}
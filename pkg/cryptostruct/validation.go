@@ -0,0 +1,104 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ValidationError is returned when a field of a plain or secure type cannot be transformed,
+// e.g. because it is missing on the other type or because the types of both fields do not match.
+type ValidationError struct {
+	// Type is the struct type declaring the field
+	Type reflect.Type
+	// Field is the name of the field, it is empty when the struct type itself cannot be transformed
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %v", e.Type, e.Err)
+	}
+	return fmt.Sprintf("field %s of %s: %v", e.Field, e.Type, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateTransformConfig verifies that the plain and secure type in c can be transformed into each other,
+// including all nested structs. All invalid fields are reported as *ValidationError, joined into a single error.
+// It is meant to be called in unit tests for every pair of types, as the Encrypter and Decrypter only report
+// the invalid fields of the structs they encounter.
+func ValidateTransformConfig(c TransformConfig) error {
+	if c.Decrypted == nil || c.Encrypted == nil {
+		return fmt.Errorf("transform config must declare both the decrypted and the encrypted type")
+	}
+	plainType, _ := structType(reflect.TypeOf(c.Decrypted))
+	secureType, _ := structType(reflect.TypeOf(c.Encrypted))
+
+	v := validator{seen: make(map[planKey]bool)}
+	v.validate(plainType, secureType, encryptMode)
+	v.validate(secureType, plainType, decryptMode)
+	return errors.Join(v.errs...)
+}
+
+type validator struct {
+	seen map[planKey]bool
+	errs []error
+}
+
+func (v *validator) validate(input reflect.Type, output reflect.Type, mode transformMode) {
+	key := planKey{input: input, output: output, mode: mode}
+	if v.seen[key] {
+		return
+	}
+	v.seen[key] = true
+
+	p, err := compilePlan(input, output, mode)
+	if err != nil {
+		// Unwrap the errors joined by compilePlan, so every invalid field is reported once
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			v.errs = append(v.errs, joined.Unwrap()...)
+		} else {
+			v.errs = append(v.errs, err)
+		}
+	}
+	if p == nil {
+		return
+	}
+
+	for _, f := range p.fields {
+		v.validateValue(f.value, mode)
+	}
+}
+
+// validateValue validates the nested structs in the value plan
+func (v *validator) validateValue(p *valuePlan, mode transformMode) {
+	if p == nil {
+		return
+	}
+	if p.op == opTransformer {
+		v.validate(p.input, p.output, mode)
+		return
+	}
+	v.validateValue(p.elem, mode)
+	v.validateValue(p.key, mode)
+}
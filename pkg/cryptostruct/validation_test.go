@@ -0,0 +1,133 @@
+/*
+ * Copyright 2024 CoreLayer BV
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package cryptostruct
+
+import (
+	"errors"
+	"testing"
+)
+
+type testInvalidData struct {
+	Name    string            `secure:"true"`
+	Missing string            `secure:"true"`
+	Plain   int               `secure:"false"`
+	Count   int               `secure:"true"`
+	Details testInvalidNested `secure:"true"`
+}
+
+func (d testInvalidData) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testInvalidData{},
+		Encrypted: testSecureInvalidData{},
+	}
+}
+
+type testSecureInvalidData struct {
+	Name         string                  `secure:"true"`
+	Plain        string                  `secure:"false"`
+	Count        int                     `secure:"true"`
+	Details      testSecureInvalidNested `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+type testInvalidNested struct {
+	Value int    `secure:"true"`
+	Extra string `secure:"false"`
+}
+
+func (d testInvalidNested) GetTransformConfig() TransformConfig {
+	return TransformConfig{
+		Decrypted: testInvalidNested{},
+		Encrypted: testSecureInvalidNested{},
+	}
+}
+
+type testSecureInvalidNested struct {
+	Value        string `secure:"true"`
+	CryptoParams CryptoParams
+}
+
+func TestValidateTransformConfig(t *testing.T) {
+	configs := []TransformConfig{
+		testData{}.GetTransformConfig(),
+		testMapData{}.GetTransformConfig(),
+		testPointerData{}.GetTransformConfig(),
+		testFlattenedService{}.GetTransformConfig(),
+		testNestedService{}.GetTransformConfig(),
+		(&testReceiverData{}).GetTransformConfig(),
+	}
+	for _, c := range configs {
+		if err := ValidateTransformConfig(c); err != nil {
+			t.Errorf("ValidateTransformConfig(%T) error = %v", c.Decrypted, err)
+		}
+	}
+}
+
+func TestValidateTransformConfig_Invalid(t *testing.T) {
+	err := ValidateTransformConfig(testInvalidData{}.GetTransformConfig())
+	if err == nil {
+		t.Fatal("ValidateTransformConfig() expected error")
+	}
+
+	// Every invalid field is reported, in both directions and for nested structs
+	reported := make(map[string]bool)
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var validationErr *ValidationError
+		if !errors.As(e, &validationErr) {
+			t.Fatalf("error %v is not a ValidationError", e)
+		}
+		reported[validationErr.Type.Name()+"."+validationErr.Field] = true
+	}
+
+	expected := []string{
+		"testInvalidData.Missing",
+		"testInvalidData.Plain",
+		"testInvalidData.Count",
+		"testInvalidNested.Extra",
+		"testSecureInvalidData.Plain",
+		"testSecureInvalidData.Count",
+		// testSecureInvalidNested does not implement DecryptTransformer
+		"testSecureInvalidData.Details",
+	}
+	for _, field := range expected {
+		if !reported[field] {
+			t.Errorf("field %s is not reported in %v", field, err)
+		}
+	}
+	if len(reported) != len(expected) {
+		t.Errorf("reported %v, want %v", reported, expected)
+	}
+}
+
+func TestEncrypter_Transform_Invalid(t *testing.T) {
+	input := testInvalidData{}
+	_, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), input.GetTransformConfig()).Transform(input)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("Transform() error = %v, want ValidationError", err)
+	}
+}
+
+func TestTransform_IncompleteTransformConfig(t *testing.T) {
+	if _, err := NewEncrypter(testMasterKey, newTestCryptoParams(t), TransformConfig{Decrypted: testData{}}).Transform(newTestData()); err == nil {
+		t.Error("Encrypter.Transform() expected error without encrypted type")
+	}
+	if _, err := NewDecrypter(testMasterKey, TransformConfig{Encrypted: testSecureData{}}).Transform(newTestEncryptedData(t)); err == nil {
+		t.Error("Decrypter.Transform() expected error without decrypted type")
+	}
+}